@@ -4,6 +4,7 @@ import (
 	"github.com/gabibotos/go-srv/log"
 	"github.com/gabibotos/go-srv/middleware"
 	"github.com/gabibotos/go-srv/srv"
+	"github.com/gabibotos/go-srv/srv/schema"
 	"github.com/gorilla/mux"
 	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/plugin/ochttp/propagation/b3"
@@ -11,7 +12,9 @@ import (
 	"go.opencensus.io/trace"
 	"go.opencensus.io/zpages"
 
+	"errors"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/heptiolabs/healthcheck"
 )
@@ -39,6 +42,10 @@ type (
 		server srv.Server
 		app *mux.Router
 		systemApp *mux.Router
+
+		// draining is set once the shutdown started, to fail the readiness checks
+		draining int32
+		started  int32
 	}
 )
 
@@ -58,6 +65,7 @@ func New(log log.Logger, opts ...Option) Server {
 		middleware.ProxyHeaders,
 		middleware.Recover(log),
 		middleware.LogRequests(log),
+		middleware.ClientCertIdentity,
 	)
 
 	s := appsrv{
@@ -70,16 +78,25 @@ func New(log log.Logger, opts ...Option) Server {
 
 	s.opts = newDefaultWithOptions(&s, opts...)
 
+	health.AddReadinessCheck("shutdown", func() error {
+		if atomic.LoadInt32(&s.draining) == 1 {
+			return errors.New("shutting down")
+		}
+		return nil
+	})
+
 	if s.opts.metrics != nil {
 		if pe, ok := s.opts.metrics.(http.Handler); ok {
 			s.systemApp.Handle("/metrics", pe)
 		}
-		err := view.Register(ochttp.ServerRequestCountView,
+		err := view.Register(append([]*view.View{
+			ochttp.ServerRequestCountView,
 			ochttp.ServerRequestBytesView,
 			ochttp.ServerResponseBytesView,
 			ochttp.ServerLatencyView,
 			ochttp.ServerRequestCountByMethod,
-			ochttp.ServerResponseCountByStatusCode)
+			ochttp.ServerResponseCountByStatusCode,
+		}, schema.DefaultViews...)...)
 		if err != nil {
 			panic(err)
 		}
@@ -88,6 +105,9 @@ func New(log log.Logger, opts ...Option) Server {
 
 	if s.opts.tracer != nil {
 		trace.RegisterExporter(s.opts.tracer)
+		if s.opts.sampleRate != nil {
+			trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(*s.opts.sampleRate)})
+		}
 		s.app.Use(
 			func(next http.Handler) http.Handler {
 				return &ochttp.Handler{
@@ -136,24 +156,39 @@ func (s *appsrv) Init() error {
 	srvOpts = append(srvOpts, s.opts.systemOpts...) // force admin config
 	srvOpts = append(srvOpts,
 		srv.HandlesRequestsWith(s.app), // force handler config
+		srv.OnDraining(func() {
+			atomic.StoreInt32(&s.draining, 1)
+		}),
 	)
 	s.server = srv.New(srvOpts...)
+
+	if s.opts.preStop {
+		s.systemApp.Handle("/prestop", s.server.PreStopHandler())
+	}
+	if s.opts.reload {
+		s.systemApp.Handle("/reload", s.server.ReloadHandler())
+	}
 	return nil
 }
 
-// Start the application an its enabled modules
+// Start the application an its enabled modules, it blocks until the application stopped
 func (s *appsrv) Start() error {
 	if err := s.server.Listen(); err != nil {
 		return err
 	}
 
+	atomic.StoreInt32(&s.started, 1)
 	return s.server.Serve()
 }
 
-// Stop the application an its enabled modules
+// Stop the application an its enabled modules, it waits for the stop hooks when the application was started
 func (s *appsrv) Stop() error {
 	if err := s.server.Shutdown(); err != nil {
 		return err
 	}
-	return nil
+	if atomic.LoadInt32(&s.started) == 0 {
+		return nil
+	}
+	<-s.server.Done()
+	return s.server.Err()
 }
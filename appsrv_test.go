@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv"
+	"github.com/gabibotos/go-srv/srv/schema"
+)
+
+func TestAppsrvInMemory(t *testing.T) {
+	app := &schema.MemoryFlg{Prefix: "app"}
+	sys := &schema.MemoryFlg{Prefix: "metrics"}
+
+	s := New(log.New(io.Discard, "", 0),
+		WithHTTPOption(srv.WithListeners(app)),
+		WithSystemHTTPOption(srv.WithSystemListeners(sys)),
+	)
+	s.App().HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- s.Start() }()
+
+	for url, want := range map[string]int{
+		"http://app/hello":       http.StatusOK,
+		"http://app/missing":     http.StatusNotFound,
+		"http://metrics/healthz": http.StatusOK,
+	} {
+		client := app.Client()
+		if url == "http://metrics/healthz" {
+			client = sys.Client()
+		}
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("wrong status for %s: got %d want %d", url, resp.StatusCode, want)
+		}
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("server stopped with an error: %v", err)
+	}
+	if _, err := app.Client().Get("http://app/hello"); err == nil {
+		t.Fatal("expected an error after shutdown")
+	}
+}
+
+func TestAppsrvPreStopDrains(t *testing.T) {
+	app := &schema.MemoryFlg{Prefix: "app"}
+	sys := &schema.MemoryFlg{Prefix: "metrics"}
+
+	s := New(log.New(io.Discard, "", 0),
+		WithHTTPOption(srv.WithListeners(app), srv.WithDrainDelay(300*time.Millisecond)),
+		WithSystemHTTPOption(srv.WithSystemListeners(sys)),
+		WithPreStop(),
+	)
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Start() }()
+
+	status := func(client *http.Client, url string) int {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := status(sys.Client(), "http://metrics/readyz"); code != http.StatusOK {
+		t.Fatalf("wrong readiness before shutdown: got %d want %d", code, http.StatusOK)
+	}
+
+	preStopped := make(chan int, 1)
+	go func() { preStopped <- status(sys.Client(), "http://metrics/prestop") }()
+
+	// the readiness fails while the app keeps serving during the drain delay
+	deadline := time.Now().Add(time.Second)
+	for status(sys.Client(), "http://metrics/readyz") != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatal("readiness still passing while draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := status(app.Client(), "http://app/missing"); code != http.StatusNotFound {
+		t.Fatalf("wrong status while draining: got %d want %d", code, http.StatusNotFound)
+	}
+
+	if code := <-preStopped; code != http.StatusOK {
+		t.Fatalf("wrong pre-stop status: got %d want %d", code, http.StatusOK)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("server stopped with an error: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gabibotos/go-srv/srv"
+	"github.com/gabibotos/go-srv/srv/schema"
+	flag "github.com/spf13/pflag"
+)
+
+// Config is the application configuration file, the server settings of srv.FileConfig plus the ones
+// of the application
+type Config struct {
+	srv.FileConfig `yaml:",inline"`
+
+	Observability ObservabilityConfig `json:"observability" yaml:"observability"`
+	// PreStop serves /prestop on the system listener, see WithPreStop
+	PreStop bool `json:"prestop" yaml:"prestop"`
+}
+
+// ObservabilityConfig configures the tracing, the exporters are passed with WithTraceExprt and WithMetricsExprt
+type ObservabilityConfig struct {
+	// Public doesn't trust the trace headers of the requests, see IsPublic
+	Public bool `json:"public" yaml:"public"`
+	// TraceSampleRate is the fraction of the requests traced, between 0 and 1
+	TraceSampleRate *float64 `json:"trace-sample-rate" yaml:"trace-sample-rate"`
+}
+
+// LoadConfig reads and validates an application configuration file, in YAML or JSON
+//noinspection GoUnusedExportedFunction
+func LoadConfig(path string) (*Config, error) {
+	var cfg Config
+	if err := srv.DecodeConfigFile(path, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %v", path, err)
+	}
+	return &cfg, nil
+}
+
+// Validate checks the values of the file, the errors name the key they are about
+func (c *Config) Validate() error {
+	var errs []error
+	if err := c.FileConfig.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if r := c.Observability.TraceSampleRate; r != nil && (*r < 0 || *r > 1) {
+		errs = append(errs, fmt.Errorf("observability.trace-sample-rate: %v is not between 0 and 1", *r))
+	}
+	return errors.Join(errs...)
+}
+
+// Options builds the application options from the file, the flags of fs and the environment take precedence
+// over it. fs can be nil when the application has no flags.
+func (c *Config) Options(fs *flag.FlagSet) ([]Option, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	httpOpts, err := c.FileConfig.Options(fs)
+	if err != nil {
+		return nil, err
+	}
+	systemOpts, err := c.FileConfig.SystemOptions(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []Option{WithHTTPOption(httpOpts...), WithSystemHTTPOption(systemOpts...)}
+	if c.Observability.Public {
+		opts = append(opts, IsPublic())
+	}
+	if r := c.Observability.TraceSampleRate; r != nil {
+		opts = append(opts, WithTraceSampling(*r))
+	}
+	if c.PreStop {
+		opts = append(opts, WithPreStop())
+	}
+	return opts, nil
+}
+
+// Reloader returns a ReloadFunc for WithReload, reading the application configuration file at path again,
+// see srv.FileConfig.Reloader
+func (c *Config) Reloader(path string, fs *flag.FlagSet) srv.ReloadFunc {
+	return func(context.Context) ([]schema.ServerListener, error) {
+		next, err := LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		next.EnvPrefix = c.EnvPrefix
+		return next.ServerListeners(fs)
+	}
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type clientIdentityKey struct{}
+
+// ClientIdentity is the normalized identity of a verified client certificate
+type ClientIdentity struct {
+	// CommonName is the subject common name
+	CommonName string
+	// SPIFFEID is the first spiffe:// URI SAN, if any
+	SPIFFEID string
+	// URIs are all the URI SANs, including the SPIFFE ID
+	URIs []string
+	// DNSNames are the DNS SANs
+	DNSNames []string
+	// Serial is the certificate serial number in decimal
+	Serial string
+}
+
+// TrustDomain returns the trust domain of the SPIFFE ID, or an empty string without one
+func (id *ClientIdentity) TrustDomain() string {
+	if id.SPIFFEID == "" {
+		return ""
+	}
+	u, err := url.Parse(id.SPIFFEID)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// NewClientIdentity extracts the identity of a client certificate
+func NewClientIdentity(cert *x509.Certificate) *ClientIdentity {
+	id := &ClientIdentity{
+		CommonName: cert.Subject.CommonName,
+		DNSNames:   cert.DNSNames,
+		Serial:     cert.SerialNumber.String(),
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+		if id.SPIFFEID == "" && strings.EqualFold(u.Scheme, "spiffe") {
+			id.SPIFFEID = u.String()
+		}
+	}
+	return id
+}
+
+// ClientIdentityFromContext returns the client identity stored by ClientCertIdentity
+func ClientIdentityFromContext(ctx context.Context) (*ClientIdentity, bool) {
+	id, ok := ctx.Value(clientIdentityKey{}).(*ClientIdentity)
+	return id, ok
+}
+
+// ClientCertIdentity stores the identity of the verified client certificate in the request context.
+// Certificates which were requested but not verified against the client CAs are ignored.
+func ClientCertIdentity(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := ClientIdentityFromContext(r.Context()); !ok {
+			if id := verifiedIdentity(r); id != nil {
+				r = r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, id))
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func verifiedIdentity(r *http.Request) *ClientIdentity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return NewClientIdentity(r.TLS.VerifiedChains[0][0])
+}
+
+// ClientIdentityPolicy matches client identities. A client is allowed when it matches any of the
+// listed values, an empty policy allows any verified client certificate.
+type ClientIdentityPolicy struct {
+	CommonNames  []string
+	SPIFFEIDs    []string
+	TrustDomains []string
+	DNSNames     []string
+}
+
+// Allows tells if the identity satisfies the policy
+func (p ClientIdentityPolicy) Allows(id *ClientIdentity) bool {
+	if id == nil {
+		return false
+	}
+	if len(p.CommonNames) == 0 && len(p.SPIFFEIDs) == 0 && len(p.TrustDomains) == 0 && len(p.DNSNames) == 0 {
+		return true
+	}
+
+	if id.CommonName != "" && contains(p.CommonNames, id.CommonName, false) {
+		return true
+	}
+	if id.SPIFFEID != "" && contains(p.SPIFFEIDs, id.SPIFFEID, false) {
+		return true
+	}
+	if td := id.TrustDomain(); td != "" && contains(p.TrustDomains, td, true) {
+		return true
+	}
+	for _, n := range id.DNSNames {
+		if contains(p.DNSNames, n, true) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, v string, fold bool) bool {
+	for _, s := range values {
+		if s == v || fold && strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireClientIdentity answers 403 Forbidden unless the verified client certificate satisfies the policy
+func RequireClientIdentity(p ClientIdentityPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return ClientCertIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _ := ClientIdentityFromContext(r.Context())
+			if !p.Allows(id) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func withClientCert(r *http.Request, cert *x509.Certificate) *http.Request {
+	r.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+	return r
+}
+
+func TestNewClientIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/sa/billing")
+	cert := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "billing"},
+		SerialNumber: big.NewInt(42),
+		DNSNames:     []string{"billing.internal"},
+		URIs:         []*url.URL{spiffe},
+	}
+
+	id := NewClientIdentity(cert)
+	if id.CommonName != "billing" || id.Serial != "42" || id.SPIFFEID != spiffe.String() {
+		t.Fatalf("wrong identity: got %+v", id)
+	}
+	if td := id.TrustDomain(); td != "example.org" {
+		t.Fatalf("wrong trust domain: got %s want %s", td, "example.org")
+	}
+}
+
+func TestRequireClientIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/sa/billing")
+	billing := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, SerialNumber: big.NewInt(1), URIs: []*url.URL{spiffe}}
+	other := &x509.Certificate{Subject: pkix.Name{CommonName: "other"}, SerialNumber: big.NewInt(2)}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, found := ClientIdentityFromContext(r.Context()); !found {
+			t.Fatal("identity missing from the request context")
+		}
+	})
+
+	tests := []struct {
+		name   string
+		policy ClientIdentityPolicy
+		cert   *x509.Certificate
+		status int
+	}{
+		{"any verified client", ClientIdentityPolicy{}, other, http.StatusOK},
+		{"no client certificate", ClientIdentityPolicy{}, nil, http.StatusForbidden},
+		{"common name", ClientIdentityPolicy{CommonNames: []string{"billing"}}, billing, http.StatusOK},
+		{"trust domain", ClientIdentityPolicy{TrustDomains: []string{"example.org"}}, billing, http.StatusOK},
+		{"spiffe id", ClientIdentityPolicy{SPIFFEIDs: []string{spiffe.String()}}, billing, http.StatusOK},
+		{"mismatch", ClientIdentityPolicy{TrustDomains: []string{"example.org"}}, other, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.cert != nil {
+			req = withClientCert(req, tt.cert)
+		}
+		rec := httptest.NewRecorder()
+		RequireClientIdentity(tt.policy)(ok).ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: wrong status: got %d want %d", tt.name, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestClientCertIdentityIgnoresUnverified(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{SerialNumber: big.NewInt(1)}}}
+
+	ClientCertIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, found := ClientIdentityFromContext(r.Context()); found {
+			t.Fatal("unverified certificate used as identity")
+		}
+	})).ServeHTTP(httptest.NewRecorder(), req)
+}
@@ -15,6 +15,10 @@ type (
 		systemOpts []srv.Option
 		httpOpts []srv.Option
 		isPublic  bool
+		// sampleRate is the fraction of the requests traced, the opencensus default when nil
+		sampleRate *float64
+		preStop   bool
+		reload    bool
 
 		tracer  trace.Exporter
 		metrics view.Exporter
@@ -77,6 +81,63 @@ func IsPublic() Option {
 	}
 }
 
+// OnStart runs the hooks once the listeners are bound, Start fails if one of them does
+//noinspection GoUnusedExportedFunction
+func OnStart(hooks ...srv.LifecycleHook) Option {
+	return WithHTTPOption(srv.OnStart(hooks...))
+}
+
+// OnReady runs the hooks once the application is serving
+//noinspection GoUnusedExportedFunction
+func OnReady(hooks ...srv.LifecycleHook) Option {
+	return WithHTTPOption(srv.OnReady(hooks...))
+}
+
+// OnStopping runs the hooks in reverse order when Stop is called, before the readiness fails
+//noinspection GoUnusedExportedFunction
+func OnStopping(hooks ...srv.LifecycleHook) Option {
+	return WithHTTPOption(srv.OnStopping(hooks...))
+}
+
+// OnStopped runs the hooks in reverse order once the application stopped, Stop returns their errors
+//noinspection GoUnusedExportedFunction
+func OnStopped(hooks ...srv.LifecycleHook) Option {
+	return WithHTTPOption(srv.OnStopped(hooks...))
+}
+
+// WithPreStop serves /prestop on the system listener, for a Kubernetes preStop hook. It fails the readiness,
+// keeps serving for the drain delay (see srv.WithDrainDelay) and then shuts the server down.
+//noinspection GoUnusedExportedFunction
+func WithPreStop() Option {
+	return func(opts *options) {
+		opts.preStop = true
+	}
+}
+
+// WithReload reloads the configuration on SIGHUP and on a POST to /reload on the system listener,
+// see srv.ReloadsWith. Use OnReload to apply the settings of the handlers.
+//noinspection GoUnusedExportedFunction
+func WithReload(load srv.ReloadFunc) Option {
+	return func(opts *options) {
+		opts.reload = true
+		opts.httpOpts = append(opts.httpOpts, srv.ReloadsWith(load))
+	}
+}
+
+// OnReload runs the hooks after each reload of the configuration
+//noinspection GoUnusedExportedFunction
+func OnReload(hooks ...srv.LifecycleHook) Option {
+	return WithHTTPOption(srv.OnReload(hooks...))
+}
+
+// WithTraceSampling traces the fraction (between 0 and 1) of the requests, once a trace exporter is set
+//noinspection GoUnusedExportedFunction
+func WithTraceSampling(rate float64) Option {
+	return func(opts *options) {
+		opts.sampleRate = &rate
+	}
+}
+
 // WithTraceExprt enable opencensus trace exporting
 //noinspection GoUnusedExportedFunction
 func WithTraceExprt(exp trace.Exporter) Option {
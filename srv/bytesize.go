@@ -29,6 +29,11 @@ func (b *ByteSize) Set(value string) error {
 	return nil
 }
 
+// UnmarshalText sets the value from a human readable size like 1MB, for configuration files
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	return b.Set(string(text))
+}
+
 // Type returns the type of the pflag value (pflag value interface)
 func (b *ByteSize) Type() string {
 	return "byte-size"
@@ -0,0 +1,558 @@
+package srv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig is the server configuration read from a YAML or JSON file. The settings missing from the file
+// keep their defaults, the flags set on the command line and the environment variables take precedence.
+type FileConfig struct {
+	// Schemes enables the listeners of these schemes, like the scheme flag
+	Schemes         []string  `json:"schemes" yaml:"schemes"`
+	CleanupTimeout  *Duration `json:"cleanup-timeout" yaml:"cleanup-timeout"`
+	ShutdownTimeout *Duration `json:"shutdown-timeout" yaml:"shutdown-timeout"`
+	DrainDelay      *Duration `json:"shutdown-drain-delay" yaml:"shutdown-drain-delay"`
+	MaxHeaderSize   *ByteSize `json:"max-header-size" yaml:"max-header-size"`
+
+	HSTS *HSTSConfig `json:"hsts" yaml:"hsts"`
+
+	Listeners       []ListenerConfig `json:"listeners" yaml:"listeners"`
+	SystemListeners []ListenerConfig `json:"system-listeners" yaml:"system-listeners"`
+
+	// EnvPrefix is the prefix given to BindEnv, the environment variables of the listeners of the file
+	// are named after it, see EnvName
+	EnvPrefix string `json:"-" yaml:"-"`
+}
+
+// HSTSConfig enables HSTS, see EnableHSTS and RedirectsToHTTPS
+type HSTSConfig struct {
+	MaxAge  Duration `json:"max-age" yaml:"max-age"`
+	Preload bool     `json:"preload" yaml:"preload"`
+	// Redirect sends the plain http requests to the https listener, except for the exempt paths
+	Redirect       bool     `json:"redirect" yaml:"redirect"`
+	RedirectExempt []string `json:"redirect-exempt" yaml:"redirect-exempt"`
+}
+
+// ListenerConfig describes a listener, its name is the prefix of its flags. The settings are pointers,
+// so a zero value in the file, like keep-alive: 0s, is told apart from a missing one.
+type ListenerConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// Scheme is http (the default), https or unix
+	Scheme string `json:"scheme" yaml:"scheme"`
+
+	Host         *string   `json:"host" yaml:"host"`
+	Port         *int      `json:"port" yaml:"port"`
+	ListenLimit  *int      `json:"listen-limit" yaml:"listen-limit"`
+	KeepAlive    *Duration `json:"keep-alive" yaml:"keep-alive"`
+	ReadTimeout  *Duration `json:"read-timeout" yaml:"read-timeout"`
+	WriteTimeout *Duration `json:"write-timeout" yaml:"write-timeout"`
+
+	ProxyProtocol      *bool     `json:"proxy-protocol" yaml:"proxy-protocol"`
+	ProxyTrustedCIDRs  []string  `json:"proxy-protocol-trusted-cidrs" yaml:"proxy-protocol-trusted-cidrs"`
+	ProxyHeaderTimeout *Duration `json:"proxy-protocol-timeout" yaml:"proxy-protocol-timeout"`
+	H2C                *bool     `json:"h2c" yaml:"h2c"`
+
+	// Path and Mode (octal) of the socket of a unix listener
+	Path string `json:"path" yaml:"path"`
+	Mode string `json:"mode" yaml:"mode"`
+
+	TLS *TLSConfig `json:"tls" yaml:"tls"`
+}
+
+// TLSConfig are the settings of an https listener, an empty file name is the same as a missing one
+type TLSConfig struct {
+	Certificate       string      `json:"certificate" yaml:"certificate"`
+	Key               string      `json:"key" yaml:"key"`
+	CA                string      `json:"ca" yaml:"ca"`
+	SNICertificates   []string    `json:"sni-certificates" yaml:"sni-certificates"`
+	CertificateDir    string      `json:"certificate-dir" yaml:"certificate-dir"`
+	Profile           string      `json:"profile" yaml:"profile"`
+	MinVersion        string      `json:"min-version" yaml:"min-version"`
+	MaxVersion        string      `json:"max-version" yaml:"max-version"`
+	ClientAuth        string      `json:"client-auth" yaml:"client-auth"`
+	SessionTicketKeys string      `json:"session-ticket-keys" yaml:"session-ticket-keys"`
+	CRL               []string    `json:"crl" yaml:"crl"`
+	OCSPResponses     []string    `json:"ocsp-responses" yaml:"ocsp-responses"`
+	ReloadInterval    *Duration   `json:"reload-interval" yaml:"reload-interval"`
+	DevCertificates   *bool       `json:"dev-certificates" yaml:"dev-certificates"`
+	DevCertificateDir string      `json:"dev-certificate-dir" yaml:"dev-certificate-dir"`
+	ACME              *ACMEConfig `json:"acme" yaml:"acme"`
+}
+
+// ACMEConfig enables ACME certificates, see schema.ACMEFlg
+type ACMEConfig struct {
+	DirectoryURL string   `json:"directory-url" yaml:"directory-url"`
+	DirectoryCA  string   `json:"directory-ca" yaml:"directory-ca"`
+	CacheDir     string   `json:"cache-dir" yaml:"cache-dir"`
+	Hosts        []string `json:"hosts" yaml:"hosts"`
+	Email        string   `json:"email" yaml:"email"`
+}
+
+// Duration is a time.Duration written like 30s or 1m30s in configuration files
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// LoadConfigFile reads and validates a server configuration file
+func LoadConfigFile(path string) (*FileConfig, error) {
+	var cfg FileConfig
+	if err := DecodeConfigFile(path, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %v", path, err)
+	}
+	return &cfg, nil
+}
+
+// DecodeConfigFile decodes a .yaml, .yml or .json file into v, keys unknown to v are rejected
+func DecodeConfigFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(v)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(v)
+	default:
+		return fmt.Errorf("unsupported configuration file %s, expected .yaml, .yml or .json", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate checks the values of the file, the errors name the key they are about
+func (c *FileConfig) Validate() error {
+	var errs []error
+	fail := func(path, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	for i, scheme := range c.Schemes {
+		if !validScheme(scheme) {
+			fail(fmt.Sprintf("schemes[%d]", i), "unknown scheme %q, expected http, https or unix", scheme)
+		}
+	}
+	notNegative(fail, []string{"cleanup-timeout", "shutdown-timeout", "shutdown-drain-delay"},
+		valueOf(c.CleanupTimeout), valueOf(c.ShutdownTimeout), valueOf(c.DrainDelay))
+	if c.HSTS != nil {
+		if c.HSTS.MaxAge < 0 {
+			fail("hsts.max-age", "must not be negative")
+		}
+		for i, p := range c.HSTS.RedirectExempt {
+			if !strings.HasPrefix(p, "/") {
+				fail(fmt.Sprintf("hsts.redirect-exempt[%d]", i), "%q is not an absolute path", p)
+			}
+		}
+	}
+
+	names := make(map[string]string)
+	for _, group := range []struct {
+		key       string
+		listeners []ListenerConfig
+	}{{"listeners", c.Listeners}, {"system-listeners", c.SystemListeners}} {
+		for i, l := range group.listeners {
+			path := fmt.Sprintf("%s[%d]", group.key, i)
+			for _, err := range l.validate() {
+				errs = append(errs, fmt.Errorf("%s.%v", path, err))
+			}
+			name := l.Name
+			if name == "" {
+				name = l.scheme()
+			}
+			if other, ok := names[name]; ok {
+				fail(path+".name", "listener %q is already defined by %s", name, other)
+			}
+			names[name] = path
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (l *ListenerConfig) scheme() string {
+	if l.Scheme == "" {
+		return schema.SchemeHTTP
+	}
+	return l.Scheme
+}
+
+func (l *ListenerConfig) validate() []error {
+	var errs []error
+	fail := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	scheme := l.scheme()
+	if !validScheme(scheme) {
+		fail("scheme", "unknown scheme %q, expected http, https or unix", scheme)
+	}
+	if l.Port != nil && (*l.Port < 0 || *l.Port > 65535) {
+		fail("port", "%d is out of range", *l.Port)
+	}
+	if l.ListenLimit != nil && *l.ListenLimit < 0 {
+		fail("listen-limit", "must not be negative")
+	}
+	notNegative(fail, []string{"keep-alive", "read-timeout", "write-timeout", "proxy-protocol-timeout"},
+		valueOf(l.KeepAlive), valueOf(l.ReadTimeout), valueOf(l.WriteTimeout), valueOf(l.ProxyHeaderTimeout))
+	for i, cidr := range l.ProxyTrustedCIDRs {
+		if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+			fail(fmt.Sprintf("proxy-protocol-trusted-cidrs[%d]", i), "%q is not a CIDR", cidr)
+		}
+	}
+
+	if scheme == schema.SchemeUnix {
+		if l.Path == "" {
+			fail("path", "unix listeners need a socket path")
+		}
+		if l.Host != nil {
+			fail("host", "unix listeners have a path instead")
+		}
+		if l.Port != nil {
+			fail("port", "unix listeners have a path instead")
+		}
+		if l.Mode != "" {
+			if _, err := strconv.ParseUint(l.Mode, 8, 32); err != nil {
+				fail("mode", "%q is not an octal file mode", l.Mode)
+			}
+		}
+	} else if l.Path != "" || l.Mode != "" {
+		fail("path", "only unix listeners have a socket path")
+	}
+
+	if l.TLS == nil {
+		return errs
+	}
+	if scheme != schema.SchemeHTTPS {
+		fail("tls", "only https listeners have TLS settings")
+		return errs
+	}
+	t := l.TLS
+	switch t.Profile {
+	case "", schema.TLSProfileModern, schema.TLSProfileIntermediate, schema.TLSProfileLegacy:
+	default:
+		fail("tls.profile", "unknown profile %q, expected modern, intermediate or legacy", t.Profile)
+	}
+	for i, v := range []string{t.MinVersion, t.MaxVersion} {
+		switch v {
+		case "", "1.0", "1.1", "1.2", "1.3":
+		default:
+			fail([]string{"tls.min-version", "tls.max-version"}[i], "unknown version %q, expected 1.0, 1.1, 1.2 or 1.3", v)
+		}
+	}
+	switch t.ClientAuth {
+	case "", schema.ClientAuthNone, schema.ClientAuthRequest, schema.ClientAuthVerifyIfGiven, schema.ClientAuthRequire:
+	default:
+		fail("tls.client-auth", "unknown mode %q, expected none, request, verify-if-given or require", t.ClientAuth)
+	}
+	if (t.Certificate == "") != (t.Key == "") {
+		fail("tls.key", "certificate and key go together")
+	}
+	if t.ACME != nil && len(t.ACME.Hosts) == 0 {
+		fail("tls.acme.hosts", "at least one host is required")
+	}
+	return errs
+}
+
+func notNegative(fail func(key, format string, args ...interface{}), keys []string, values ...Duration) {
+	for i, d := range values {
+		if d < 0 {
+			fail(keys[i], "must not be negative")
+		}
+	}
+}
+
+func validScheme(scheme string) bool {
+	switch scheme {
+	case schema.SchemeHTTP, schema.SchemeHTTPS, schema.SchemeUnix:
+		return true
+	}
+	return false
+}
+
+// Options builds the server options from the file. The settings given with a flag of fs are left alone,
+// call BindEnv first so the environment variables count as given. fs can be nil when the server has no flags.
+func (c *FileConfig) Options(fs *flag.FlagSet) ([]Option, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	changed := func(name string) bool {
+		return fs != nil && fs.Changed(name)
+	}
+
+	var opts []Option
+	if len(c.Schemes) > 0 && !changed("scheme") {
+		opts = append(opts, EnablesSchemes(c.Schemes...))
+	}
+	if c.CleanupTimeout != nil && !changed("cleanup-timeout") {
+		opts = append(opts, WithCleanupTimeout(time.Duration(*c.CleanupTimeout)))
+	}
+	if c.ShutdownTimeout != nil && !changed("shutdown-timeout") {
+		opts = append(opts, WithShutdownTimeout(time.Duration(*c.ShutdownTimeout)))
+	}
+	if c.DrainDelay != nil && !changed("shutdown-drain-delay") {
+		opts = append(opts, WithDrainDelay(time.Duration(*c.DrainDelay)))
+	}
+	if c.MaxHeaderSize != nil && !changed("max-header-size") {
+		opts = append(opts, WithMaxHeaderSize(c.MaxHeaderSize.Get()))
+	}
+	if c.HSTS != nil {
+		opts = append(opts, EnableHSTS(time.Duration(c.HSTS.MaxAge), c.HSTS.Preload))
+		if c.HSTS.Redirect {
+			opts = append(opts, RedirectsToHTTPS(c.HSTS.RedirectExempt...))
+		}
+	}
+
+	if len(c.Listeners) > 0 {
+		listeners, err := buildListeners("listeners", c.Listeners, fs, c.EnvPrefix)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithListeners(listeners[0], listeners[1:]...))
+	}
+	return opts, nil
+}
+
+// SystemOptions builds the options for the system listeners of the file, with the same precedence as Options
+func (c *FileConfig) SystemOptions(fs *flag.FlagSet) ([]Option, error) {
+	if len(c.SystemListeners) == 0 {
+		return nil, nil
+	}
+	listeners, err := buildListeners("system-listeners", c.SystemListeners, fs, c.EnvPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return []Option{func(s *options) {
+		s.systemListeners = listeners
+	}}, nil
+}
+
+// ServerListeners builds the listeners then the system listeners of the file, with the same precedence as Options
+func (c *FileConfig) ServerListeners(fs *flag.FlagSet) ([]schema.ServerListener, error) {
+	listeners, err := buildListeners("listeners", c.Listeners, fs, c.EnvPrefix)
+	if err != nil {
+		return nil, err
+	}
+	system, err := buildListeners("system-listeners", c.SystemListeners, fs, c.EnvPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return append(listeners, system...), nil
+}
+
+// Reloader returns a ReloadFunc, see ReloadsWith, reading the configuration file at path again and building its
+// listeners like ServerListeners. The running listeners the file doesn't declare, like the default ones when it
+// declares none, are reported as removed and keep serving until a restart.
+func (c *FileConfig) Reloader(path string, fs *flag.FlagSet) ReloadFunc {
+	return func(context.Context) ([]schema.ServerListener, error) {
+		next, err := LoadConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		next.EnvPrefix = c.EnvPrefix
+		return next.ServerListeners(fs)
+	}
+}
+
+func buildListeners(key string, configs []ListenerConfig, fs *flag.FlagSet, envPrefix string) ([]schema.ServerListener, error) {
+	listeners := make([]schema.ServerListener, 0, len(configs))
+	for i, cfg := range configs {
+		l, err := cfg.listener(fs, envPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %v", key, i, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listener creates the listener with the defaults of its flags, the values of the file, then the flags
+// of fs and the environment, named after envPrefix
+func (l *ListenerConfig) listener(fs *flag.FlagSet, envPrefix string) (schema.ServerListener, error) {
+	defaults := flag.NewFlagSet(l.Name, flag.ContinueOnError)
+
+	var listener schema.ServerListener
+	switch l.scheme() {
+	case schema.SchemeHTTPS:
+		t := &schema.TLSFlg{}
+		t.Prefix = l.Name
+		t.RegisterFlags(defaults)
+		l.applyHTTP(&t.HTTPFlg)
+		l.TLS.apply(t)
+		listener = t
+	case schema.SchemeUnix:
+		u := &schema.UnixFlg{Prefix: l.Name}
+		u.RegisterFlags(defaults)
+		setIf(&u.Path, l.Path)
+		if l.Mode != "" {
+			if err := defaults.Set(schema.FlagName(l.Name, "socket-mode"), l.Mode); err != nil {
+				return nil, fmt.Errorf("mode: %v", err)
+			}
+		}
+		setPtr(&u.ListenLimit, l.ListenLimit)
+		setDuration(&u.ReadTimeout, l.ReadTimeout)
+		setDuration(&u.WriteTimeout, l.WriteTimeout)
+		listener = u
+	default:
+		h := &schema.HTTPFlg{Prefix: l.Name}
+		h.RegisterFlags(defaults)
+		l.applyHTTP(h)
+		listener = h
+	}
+
+	if err := overrideFlags(defaults, fs, envPrefix); err != nil {
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (l *ListenerConfig) applyHTTP(h *schema.HTTPFlg) {
+	setPtr(&h.Host, l.Host)
+	setPtr(&h.Port, l.Port)
+	setPtr(&h.ListenLimit, l.ListenLimit)
+	setDuration(&h.KeepAlive, l.KeepAlive)
+	setDuration(&h.ReadTimeout, l.ReadTimeout)
+	setDuration(&h.WriteTimeout, l.WriteTimeout)
+	setPtr(&h.ProxyProtocol, l.ProxyProtocol)
+	setSliceIf(&h.ProxyTrustedCIDRs, l.ProxyTrustedCIDRs)
+	setDuration(&h.ProxyHeaderTimeout, l.ProxyHeaderTimeout)
+	setPtr(&h.H2C, l.H2C)
+}
+
+func (c *TLSConfig) apply(t *schema.TLSFlg) {
+	if c == nil {
+		return
+	}
+	setIf(&t.Cert, c.Certificate)
+	setIf(&t.CertKey, c.Key)
+	setIf(&t.CACert, c.CA)
+	setSliceIf(&t.SNICerts, c.SNICertificates)
+	setIf(&t.CertDir, c.CertificateDir)
+	setIf(&t.Profile, c.Profile)
+	setIf(&t.MinVersion, c.MinVersion)
+	setIf(&t.MaxVersion, c.MaxVersion)
+	setIf(&t.ClientAuth, c.ClientAuth)
+	setIf(&t.SessionTicketKeys, c.SessionTicketKeys)
+	setSliceIf(&t.CRLFiles, c.CRL)
+	setSliceIf(&t.OCSPResponses, c.OCSPResponses)
+	setDuration(&t.ReloadInterval, c.ReloadInterval)
+	setPtr(&t.DevCerts, c.DevCertificates)
+	setIf(&t.DevCertDir, c.DevCertificateDir)
+	if c.ACME != nil {
+		t.ACME = schema.ACMEFlg{
+			Enabled:      true,
+			DirectoryURL: c.ACME.DirectoryURL,
+			DirectoryCA:  c.ACME.DirectoryCA,
+			CacheDir:     c.ACME.CacheDir,
+			Hosts:        c.ACME.Hosts,
+			Email:        c.ACME.Email,
+		}
+	}
+}
+
+// setIf keeps the default when the file leaves the value out, for the settings where the zero value is no setting
+func setIf[T comparable](dst *T, v T) {
+	var zero T
+	if v != zero {
+		*dst = v
+	}
+}
+
+// setPtr keeps the default when the file leaves the value out, a zero value in the file is applied
+func setPtr[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func setDuration(dst *time.Duration, v *Duration) {
+	if v != nil {
+		*dst = time.Duration(*v)
+	}
+}
+
+func valueOf[T any](v *T) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	return *v
+}
+
+func setSliceIf(dst *[]string, v []string) {
+	if len(v) > 0 {
+		*dst = v
+	}
+}
+
+// overrideFlags sets the flags which were given on the command line of fs, or else in the environment,
+// under the same variables as BindEnv
+func overrideFlags(to, fs *flag.FlagSet, envPrefix string) error {
+	var err error
+	to.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		if fs != nil {
+			if given := fs.Lookup(f.Name); given != nil && given.Changed {
+				if serr := copyFlag(f, given); serr != nil {
+					err = fmt.Errorf("invalid value for flag --%s: %v", f.Name, serr)
+				}
+				return
+			}
+		}
+		v, key, lerr := lookupEnv(envKeys(envPrefix, f.Name)...)
+		if lerr != nil {
+			err = lerr
+			return
+		}
+		if key != "" {
+			if serr := setFlag(to, f, v); serr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", v, key, serr)
+			}
+		}
+	})
+	return err
+}
+
+func copyFlag(dst, src *flag.Flag) error {
+	if from, ok := src.Value.(flag.SliceValue); ok {
+		if to, ok := dst.Value.(flag.SliceValue); ok {
+			return to.Replace(from.GetSlice())
+		}
+	}
+	return dst.Value.Set(src.Value.String())
+}
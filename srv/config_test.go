@@ -0,0 +1,223 @@
+package srv
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+	flag "github.com/spf13/pflag"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFilePrecedence(t *testing.T) {
+	path := writeConfig(t, "srv.yaml", `
+schemes: [http]
+shutdown-timeout: 20s
+max-header-size: 64kB
+listeners:
+  - name: app
+    port: 8081
+    read-timeout: 5s
+  - port: 8082
+system-listeners:
+  - name: metrics
+    host: 127.0.0.1
+    port: 10239
+`)
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	(&schema.HTTPFlg{Prefix: "app"}).RegisterFlags(fs)
+	fs.Duration("shutdown-timeout", 0, "")
+	if err := fs.Parse([]string{"--app-port=9000", "--shutdown-timeout=5s"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PORT", "7000")
+
+	opts, err := cfg.Options(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	systemOpts, err := cfg.SystemOptions(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newDefaultWithOptions(append(opts, systemOpts...)...)
+
+	if o.shutdownTimeout != nil {
+		t.Fatalf("the file overrode the shutdown-timeout flag: got %s", *o.shutdownTimeout)
+	}
+	if o.maxHeaderSize == nil || o.maxHeaderSize.Get() != 64000 {
+		t.Fatalf("wrong max header size: got %v want 64kB", o.maxHeaderSize)
+	}
+	if len(o.listeners) != 2 || len(o.systemListeners) != 1 {
+		t.Fatalf("wrong listeners: got %d and %d system ones", len(o.listeners), len(o.systemListeners))
+	}
+
+	app := o.listeners[0].(*schema.HTTPFlg)
+	if app.Port != 9000 {
+		t.Fatalf("wrong port: got %d want %d, the flag takes precedence", app.Port, 9000)
+	}
+	if app.ReadTimeout != 5*time.Second || app.WriteTimeout != 30*time.Second {
+		t.Fatalf("wrong timeouts: got %s and %s want 5s from the file and 30s by default", app.ReadTimeout, app.WriteTimeout)
+	}
+	if port := o.listeners[1].(*schema.HTTPFlg).Port; port != 7000 {
+		t.Fatalf("wrong port: got %d want %d, the environment takes precedence", port, 7000)
+	}
+	if host := o.systemListeners[0].(*schema.HTTPFlg).Host; host != "127.0.0.1" {
+		t.Fatalf("wrong host: got %s want %s", host, "127.0.0.1")
+	}
+}
+
+func TestConfigFileZeroValues(t *testing.T) {
+	path := writeConfig(t, "srv.yaml", `
+listeners:
+  - name: app
+    keep-alive: 0s
+    write-timeout: 0s
+    h2c: false
+  - name: secure
+    scheme: https
+    tls:
+      reload-interval: 0s
+`)
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := cfg.Options(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newDefaultWithOptions(opts...)
+
+	app := o.listeners[0].(*schema.HTTPFlg)
+	if app.KeepAlive != 0 || app.WriteTimeout != 0 {
+		t.Fatalf("zero values of the file not applied: got keep-alive %s and write-timeout %s", app.KeepAlive, app.WriteTimeout)
+	}
+	if app.ReadTimeout != 30*time.Second {
+		t.Fatalf("wrong read timeout: got %s want %s by default", app.ReadTimeout, 30*time.Second)
+	}
+	if secure := o.listeners[1].(*schema.TLSFlg); secure.ReloadInterval != 0 {
+		t.Fatalf("wrong reload interval: got %s want 0s from the file", secure.ReloadInterval)
+	}
+}
+
+func TestConfigFileZeroTimeouts(t *testing.T) {
+	path := writeConfig(t, "srv.yaml", `
+cleanup-timeout: 0s
+shutdown-drain-delay: 0s
+`)
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := NewConfig()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.RegisterFlags(fs)
+	if err := fs.Parse([]string{"--shutdown-drain-delay=2s"}); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := cfg.Options(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(append(flags.Options(), opts...)...).(*defaultServer)
+
+	if s.CleanupTimeout != 0 {
+		t.Fatalf("wrong cleanup timeout: got %s want 0s from the file over the 10s default", s.CleanupTimeout)
+	}
+	if s.DrainDelay != 2*time.Second {
+		t.Fatalf("wrong drain delay: got %s want 2s, the flag takes precedence", s.DrainDelay)
+	}
+}
+
+func TestConfigFileEnvPrecedence(t *testing.T) {
+	path := writeConfig(t, "srv.yaml", `
+listeners:
+  - name: app
+    port: 8081
+    read-timeout: 5s
+  - name: secure
+    scheme: https
+    tls:
+      certificate: file.crt
+      key: file.key
+`)
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.EnvPrefix = "myapp"
+	t.Setenv("MYAPP_APP_PORT", "9000")
+	t.Setenv("MYAPP_SECURE_TLS_CERTIFICATE", "env.crt")
+	t.Setenv("APP_READ_TIMEOUT", "1s") // not under the prefix
+
+	opts, err := cfg.Options(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newDefaultWithOptions(opts...)
+
+	app := o.listeners[0].(*schema.HTTPFlg)
+	if app.Port != 9000 {
+		t.Fatalf("wrong port: got %d want %d, the environment takes precedence", app.Port, 9000)
+	}
+	if app.ReadTimeout != 5*time.Second {
+		t.Fatalf("wrong read timeout: got %s want 5s from the file", app.ReadTimeout)
+	}
+	secure := o.listeners[1].(*schema.TLSFlg)
+	if secure.Cert != "env.crt" || secure.CertKey != "file.key" {
+		t.Fatalf("wrong certificate: got %s and %s want env.crt and file.key", secure.Cert, secure.CertKey)
+	}
+}
+
+func TestConfigFileValidation(t *testing.T) {
+	path := writeConfig(t, "srv.json", `{
+  "schemes": ["ftp"],
+  "listeners": [
+    {"name": "app", "port": 70000},
+    {"name": "app", "tls": {"certificate": "app.crt"}},
+    {"scheme": "https", "tls": {"min-version": "1.4"}},
+    {"scheme": "unix", "path": "/tmp/app.sock", "port": 8080},
+    {"name": "socket", "scheme": "unix"}
+  ]
+}`)
+	_, err := LoadConfigFile(path)
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{
+		`schemes[0]: unknown scheme "ftp"`,
+		"listeners[0].port: 70000 is out of range",
+		"listeners[1].tls: only https listeners have TLS settings",
+		`listeners[1].name: listener "app" is already defined by listeners[0]`,
+		`listeners[2].tls.min-version: unknown version "1.4"`,
+		"listeners[3].port: unix listeners have a path instead",
+		"listeners[4].path: unix listeners need a socket path",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("missing %q in: %v", want, err)
+		}
+	}
+
+	unknown := writeConfig(t, "srv.yml", "listeners:\n  - name: app\n    prot: 8080\n")
+	if _, err := LoadConfigFile(unknown); err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Fatalf("expected the unknown key to be rejected: got %v", err)
+	}
+}
@@ -1,36 +1,127 @@
 package srv
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
 )
 
-func stringEnvOverride(orig string, def string, keys ...string) string {
-	for _, k := range keys {
-		if os.Getenv(k) != "" {
-			return os.Getenv(k)
-		}
+// legacyEnv are the variables read for the default listeners before they were derived from the flag names
+var legacyEnv = map[string][]string{
+	"host":            {"HOST"},
+	"port":            {"PORT"},
+	"tls-host":        {"TLS_HOST"},
+	"tls-port":        {"TLS_PORT"},
+	"tls-certificate": {"TLS_CERTIFICATE"},
+	"tls-key":         {"TLS_PRIVATE_KEY"},
+	"tls-ca":          {"TLS_CA_CERTIFICATE"},
+}
+
+// EnvName is the environment variable of a flag: the prefix and the flag name in upper case, dashes
+// become underscores. The flag app-read-timeout is MYAPP_APP_READ_TIMEOUT with the prefix myapp.
+func EnvName(prefix, flagName string) string {
+	name := strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+	if prefix == "" {
+		return name
 	}
-	if def != "" && orig == "" {
-		return def
+	return strings.ToUpper(strings.ReplaceAll(prefix, "-", "_")) + "_" + name
+}
+
+// BindEnv sets the flags of fs which weren't given on the command line from their environment variables,
+// see EnvName. The value can also be read from the file named by the variable with a _FILE suffix, for secrets.
+// Lists are comma separated, the repeatable file flags like tls-crl are separated like PATH.
+// Call it after parsing the command line, all invalid values are reported.
+func BindEnv(fs *flag.FlagSet, prefix string) error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Changed {
+			return
+		}
+		v, key, err := lookupEnv(envKeys(prefix, f.Name)...)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if key == "" {
+			return
+		}
+		if err := setFlag(fs, f, v); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for %s (--%s): %v", v, key, f.Name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// envKeys are the variables of a flag, its EnvName then the legacy names of the default listeners
+func envKeys(prefix, flagName string) []string {
+	return append([]string{EnvName(prefix, flagName)}, legacyEnv[flagName]...)
+}
+
+// setFlag sets the value through the flag set, so the flag counts as changed
+func setFlag(fs *flag.FlagSet, f *flag.Flag, v string) error {
+	if f.Value.Type() == "stringArray" {
+		if s, ok := f.Value.(flag.SliceValue); ok {
+			if err := s.Replace(filepath.SplitList(v)); err != nil {
+				return err
+			}
+			f.Changed = true
+			return nil
+		}
 	}
-	return orig
+	return fs.Set(f.Name, v)
 }
 
-func intEnvOverride(orig int, def int, keys ...string) int {
+// lookupEnv returns the first variable of keys which is set, directly or through a file named by KEY_FILE,
+// and the name it was found under
+func lookupEnv(keys ...string) (string, string, error) {
 	for _, k := range keys {
-		if os.Getenv(k) != "" {
-			v, err := strconv.Atoi(os.Getenv(k))
+		v, direct := os.LookupEnv(k)
+		path, indirect := os.LookupEnv(k + "_FILE")
+		switch {
+		case direct && indirect:
+			return "", "", fmt.Errorf("both %s and %s_FILE are set", k, k)
+		case direct && v != "":
+			return v, k, nil
+		case indirect && path != "":
+			data, err := os.ReadFile(path)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, k, "is not a valid number")
-				os.Exit(1)
+				return "", "", fmt.Errorf("%s_FILE: %v", k, err)
 			}
-			return v
+			return strings.TrimRight(string(data), "\r\n"), k + "_FILE", nil
 		}
 	}
+	return "", "", nil
+}
+
+// stringEnvOverride returns the first variable of keys which is set, or else orig, or def when orig is empty
+func stringEnvOverride(orig string, def string, keys ...string) (string, error) {
+	if def != "" && orig == "" {
+		orig = def
+	}
+	v, key, err := lookupEnv(keys...)
+	if err != nil || key == "" {
+		return orig, err
+	}
+	return v, nil
+}
+
+// intEnvOverride is stringEnvOverride for numbers, an invalid number is an error and orig is kept
+func intEnvOverride(orig int, def int, keys ...string) (int, error) {
 	if def != 0 && orig == 0 {
-		return def
+		orig = def
+	}
+	v, key, err := lookupEnv(keys...)
+	if err != nil || key == "" {
+		return orig, err
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return orig, fmt.Errorf("%s is not a valid number: %q", key, v)
 	}
-	return orig
+	return n, nil
 }
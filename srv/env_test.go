@@ -0,0 +1,111 @@
+package srv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+	flag "github.com/spf13/pflag"
+)
+
+func TestBindEnv(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	app := &schema.TLSFlg{}
+	app.Prefix = "app"
+	app.RegisterFlags(fs)
+	size := NewByteSize(0)
+	fs.Var(size, "max-header-size", "")
+	if err := fs.Parse([]string{"--app-tls-port=8443"}); err != nil {
+		t.Fatal(err)
+	}
+
+	key := filepath.Join(t.TempDir(), "key-path")
+	if err := os.WriteFile(key, []byte("/run/secrets/app.key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MYAPP_APP_TLS_PORT", "9443")
+	t.Setenv("MYAPP_APP_TLS_READ_TIMEOUT", "5s")
+	t.Setenv("MYAPP_MAX_HEADER_SIZE", "64kB")
+	t.Setenv("MYAPP_APP_TLS_PROXY_PROTOCOL_TRUSTED_CIDRS", "10.0.0.0/8,192.168.0.0/16")
+	t.Setenv("MYAPP_APP_TLS_CRL", "a.crl"+string(os.PathListSeparator)+"b.crl")
+	t.Setenv("MYAPP_APP_TLS_KEY_FILE", key)
+
+	if err := BindEnv(fs, "myapp"); err != nil {
+		t.Fatal(err)
+	}
+	if app.Port != 8443 {
+		t.Fatalf("wrong port: got %d want %d, the flag takes precedence", app.Port, 8443)
+	}
+	if app.ReadTimeout != 5*time.Second {
+		t.Fatalf("wrong read timeout: got %s want %s", app.ReadTimeout, 5*time.Second)
+	}
+	if size.Get() != 64000 {
+		t.Fatalf("wrong max header size: got %d want %d", size.Get(), 64000)
+	}
+	if got := strings.Join(app.ProxyTrustedCIDRs, " "); got != "10.0.0.0/8 192.168.0.0/16" {
+		t.Fatalf("wrong trusted CIDRs: got %s", got)
+	}
+	if got := strings.Join(app.CRLFiles, " "); got != "a.crl b.crl" {
+		t.Fatalf("wrong CRL files: got %s", got)
+	}
+	if app.CertKey != "/run/secrets/app.key" {
+		t.Fatalf("wrong key: got %s want %s", app.CertKey, "/run/secrets/app.key")
+	}
+	if !fs.Changed("app-tls-read-timeout") {
+		t.Fatal("flags set from the environment should count as changed")
+	}
+}
+
+func TestBindEnvErrors(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Duration("cleanup-timeout", 0, "")
+	fs.String("tls-key", "", "")
+
+	t.Setenv("CLEANUP_TIMEOUT", "soon")
+	t.Setenv("TLS_KEY", "a.key")
+	t.Setenv("TLS_KEY_FILE", "a.key.path")
+
+	err := BindEnv(fs, "")
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, want := range []string{`invalid value "soon" for CLEANUP_TIMEOUT (--cleanup-timeout)`, "both TLS_KEY and TLS_KEY_FILE are set"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("missing %q in: %v", want, err)
+		}
+	}
+}
+
+func TestIntEnvOverride(t *testing.T) {
+	t.Setenv("PORT", "http")
+	port, err := intEnvOverride(0, 8080, "PORT")
+	if err == nil || !strings.Contains(err.Error(), "PORT is not a valid number") {
+		t.Fatalf("wrong error: got %v", err)
+	}
+	if port != 8080 {
+		t.Fatalf("wrong port: got %d want %d", port, 8080)
+	}
+}
+
+func TestInvalidEnvOnlyForDefaultListeners(t *testing.T) {
+	saved := httpEnvErr
+	defer func() { httpEnvErr = saved }()
+	httpEnvErr = errors.New(`PORT is not a valid number: "http"`)
+
+	own := &schema.HTTPFlg{Host: "127.0.0.1"}
+	s := New(EnablesSchemes(schema.SchemeHTTP), WithListeners(own))
+	if err := s.Listen(); err != nil {
+		t.Fatalf("the environment of the default listeners failed a server without them: %v", err)
+	}
+	l, _ := own.Listener()
+	_ = l.Close()
+
+	s = New(EnablesSchemes(schema.SchemeHTTP), WithListeners(&DefaultHTTPFlags))
+	if err := s.Listen(); err == nil || !strings.Contains(err.Error(), "invalid environment") {
+		t.Fatalf("wrong error: got %v", err)
+	}
+}
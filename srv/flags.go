@@ -0,0 +1,73 @@
+package srv
+
+import (
+	"time"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+	flag "github.com/spf13/pflag"
+)
+
+// Config holds the settings of a server which are registered as flags. Unlike the package level RegisterFlags,
+// each server can have its own, so several servers with their own flags can run in one process.
+type Config struct {
+	// Prefix is the prefix of the flags, like the one of the listeners, so several configs share a flag set.
+	// The listeners get it too, and are named <prefix>-http and <prefix>-https.
+	Prefix string
+
+	Schemes         []string
+	CleanupTimeout  time.Duration
+	ShutdownTimeout time.Duration
+	DrainDelay      time.Duration
+	MaxHeaderSize   ByteSize
+
+	// HTTP and TLS are the default listeners, served for the http and https schemes
+	HTTP schema.HTTPFlg
+	TLS  schema.TLSFlg
+}
+
+// NewConfig returns a Config with the defaults of the flags. The environment isn't read, see BindEnv.
+func NewConfig() *Config {
+	c := &Config{}
+	c.HTTP.Host = "localhost"
+	c.HTTP.Port = 8080
+	c.TLS.Port = 8443
+	c.MaxHeaderSize = *NewByteSize(1000000)
+	// registering sets the defaults of the other flags
+	c.RegisterFlags(flag.NewFlagSet("defaults", flag.ContinueOnError))
+	return c
+}
+
+// RegisterFlags registers the flags of the config, the same ones as the package level RegisterFlags under Prefix.
+// Their environment variables, see BindEnv, are named after the prefixed flags, like ADMIN_PORT.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	if c.Prefix != "" {
+		c.HTTP.Prefix, c.HTTP.ListenerName = c.Prefix, schema.FlagName(c.Prefix, schema.SchemeHTTP)
+		c.TLS.Prefix, c.TLS.ListenerName = c.Prefix, schema.FlagName(c.Prefix, schema.SchemeHTTPS)
+	}
+	registerServerFlags(fs, c.Prefix, &c.Schemes, &c.CleanupTimeout, &c.DrainDelay, &c.ShutdownTimeout, &c.MaxHeaderSize)
+	c.HTTP.RegisterFlags(fs)
+	c.TLS.RegisterFlags(fs)
+}
+
+// Options configures a server with the config instead of the package level flags, and with its listeners.
+// Options passed after them take precedence.
+func (c *Config) Options() []Option {
+	// the https listener takes the unset values from the http one
+	c.TLS.ApplyDefaults(&c.HTTP)
+	return []Option{
+		func(o *options) {
+			o.config = c
+			o.EnabledListeners = c.Schemes
+		},
+		WithListeners(&c.HTTP, &c.TLS),
+	}
+}
+
+// registerServerFlags registers the flags of the server settings, into a Config or the package globals
+func registerServerFlags(fs *flag.FlagSet, prefix string, schemes *[]string, cleanup, drain, shutdown *time.Duration, headerSize *ByteSize) {
+	fs.StringSliceVar(schemes, schema.FlagName(prefix, "scheme"), defaultSchemes, "the listeners to enable (http, https, unix), this can be repeated and defaults to the schemes in the swagger spec")
+	fs.DurationVar(cleanup, schema.FlagName(prefix, "cleanup-timeout"), 10*time.Second, "grace period for which to wait before shutting down the server")
+	fs.DurationVar(drain, schema.FlagName(prefix, "shutdown-drain-delay"), 0, "how long to keep serving once shutting down, so load balancers see the failing readiness and stop routing traffic")
+	fs.DurationVar(shutdown, schema.FlagName(prefix, "shutdown-timeout"), defaultShutdownTimeout, "maximum duration to wait for the requests in flight on shutdown, the remaining connections are closed after it")
+	fs.Var(headerSize, schema.FlagName(prefix, "max-header-size"), "controls the maximum number of bytes the server will read parsing the request header's keys and values, including the request line. It does not limit the size of the request body")
+}
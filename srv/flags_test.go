@@ -0,0 +1,98 @@
+package srv
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+func TestConfigsAreIndependent(t *testing.T) {
+	globalCleanup := cleanupTimout
+
+	newServer := func(args ...string) (*Config, *defaultServer) {
+		cfg := NewConfig()
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg.RegisterFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		opts := append(cfg.Options(), LogsWith(log.New(io.Discard, "", 0)), WithSignalPolicy(SignalPolicy{Disabled: true}))
+		return cfg, New(opts...).(*defaultServer)
+	}
+	first, s1 := newServer("--host=127.0.0.1", "--port=0", "--cleanup-timeout=1s", "--max-header-size=16kB")
+	second, s2 := newServer("--host=127.0.0.1", "--port=0", "--scheme=http", "--scheme=https", "--shutdown-drain-delay=2s")
+
+	if s1.CleanupTimeout != time.Second || s2.CleanupTimeout != 10*time.Second {
+		t.Fatalf("wrong cleanup timeouts: got %s and %s want 1s and 10s", s1.CleanupTimeout, s2.CleanupTimeout)
+	}
+	if s1.MaxHeaderSize.Get() != 16000 || s2.MaxHeaderSize.Get() != 1000000 {
+		t.Fatalf("wrong max header sizes: got %d and %d", s1.MaxHeaderSize.Get(), s2.MaxHeaderSize.Get())
+	}
+	if s1.DrainDelay != 0 || s2.DrainDelay != 2*time.Second {
+		t.Fatalf("wrong drain delays: got %s and %s want 0s and 2s", s1.DrainDelay, s2.DrainDelay)
+	}
+	if s1.hasScheme("https") || !s2.hasScheme("https") {
+		t.Fatalf("wrong schemes: got %v and %v", first.Schemes, second.Schemes)
+	}
+	if cleanupTimout != globalCleanup {
+		t.Fatalf("the package level cleanup timeout changed: got %s want %s", cleanupTimout, globalCleanup)
+	}
+
+	// both serve in the same process, on their own listeners
+	second.Schemes = []string{"http"}
+	second.DrainDelay = 0
+	s2 = New(append(second.Options(), LogsWith(log.New(io.Discard, "", 0)), WithSignalPolicy(SignalPolicy{Disabled: true}))...).(*defaultServer)
+	for _, s := range []*defaultServer{s1, s2} {
+		s := s
+		go func() { _ = s.Serve() }()
+		<-s.Ready()
+	}
+	if a1, a2 := s1.Addrs()["http"].String(), s2.Addrs()["http"].String(); a1 == a2 {
+		t.Fatalf("servers share the listener %s", a1)
+	}
+	for _, s := range []*defaultServer{s1, s2} {
+		_ = s.Shutdown()
+		<-s.Done()
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPrefixedConfigsShareFlagSet(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("port", 9000, "the port of the application itself")
+	public, admin := NewConfig(), NewConfig()
+	public.Prefix, admin.Prefix = "public", "admin"
+	public.RegisterFlags(fs)
+	admin.RegisterFlags(fs)
+
+	if err := fs.Parse([]string{"--public-port=0", "--admin-cleanup-timeout=1s"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ADMIN_PORT", "0")
+	t.Setenv("ADMIN_HOST", "127.0.0.1")
+	t.Setenv("PORT", "1")
+	if err := BindEnv(fs, ""); err != nil {
+		t.Fatal(err)
+	}
+	if public.HTTP.Port != 0 || admin.HTTP.Port != 0 || admin.HTTP.Host != "127.0.0.1" {
+		t.Fatalf("wrong listeners: got %s:%d and %s:%d", public.HTTP.Host, public.HTTP.Port, admin.HTTP.Host, admin.HTTP.Port)
+	}
+	if admin.CleanupTimeout != time.Second || public.CleanupTimeout != 10*time.Second {
+		t.Fatalf("wrong cleanup timeouts: got %s and %s want 1s and 10s", admin.CleanupTimeout, public.CleanupTimeout)
+	}
+
+	admin.Schemes = []string{"http"}
+	s := New(append(admin.Options(), LogsWith(log.New(io.Discard, "", 0)), WithSignalPolicy(SignalPolicy{Disabled: true}))...)
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.(*defaultServer).closeListeners()
+	if _, ok := s.Addrs()["admin-http"]; !ok {
+		t.Fatalf("listener not named after the prefix: got %v", s.Addrs())
+	}
+}
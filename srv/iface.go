@@ -1,8 +1,11 @@
 package srv
 
 import (
+	"context"
 	"net"
 	"net/http"
+
+	"github.com/gabibotos/go-srv/srv/schema"
 )
 
 // Server is the interface a server implements
@@ -14,5 +17,25 @@ type Server interface {
 
 	Listen() error
 	Serve() error
+	// ServeContext serves until shut down, or until the context is done
+	ServeContext(ctx context.Context) error
 	Shutdown() error
+
+	// Addrs returns the bound addresses of the listeners by prefix, or scheme without one
+	Addrs() map[string]net.Addr
+	// Ready is closed once all listeners are serving and the OnReady hooks ran
+	Ready() <-chan struct{}
+	// Done is closed once Serve returned, Err then reports why
+	Done() <-chan struct{}
+	Err() error
+	// Conns reports the open connections and streams
+	Conns() *schema.ConnRegistry
+
+	// PreStopHandler shuts down and answers once the drain delay is over
+	PreStopHandler() http.Handler
+
+	// Reload applies the configuration which can change while serving and logs the differences
+	Reload(ctx context.Context) error
+	// ReloadHandler reloads on POST
+	ReloadHandler() http.Handler
 }
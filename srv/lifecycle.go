@@ -0,0 +1,67 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultHookTimeout bounds lifecycle hooks without their own timeout
+const defaultHookTimeout = 15 * time.Second
+
+// LifecycleHook is a named function run at a phase of the server lifecycle, see OnStart, OnReady, OnStopping and OnStopped
+type LifecycleHook struct {
+	Name string
+	// Timeout bounds the hook, defaults to 15 seconds
+	Timeout time.Duration
+	Func    func(context.Context) error
+}
+
+// run calls the hook with a context cancelled after its timeout, and stops waiting for it then
+func (h LifecycleHook) run(ctx context.Context) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- h.Func(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+type lifecycleHooks struct {
+	onStart    []LifecycleHook
+	onReady    []LifecycleHook
+	onStopping []LifecycleHook
+	onStopped  []LifecycleHook
+}
+
+// runHooks runs the hooks in order, or in reverse for the stop phases, and stops at the first error when failFast
+func (s *defaultServer) runHooks(ctx context.Context, phase string, hooks []LifecycleHook, reverse, failFast bool) error {
+	var errs []error
+	for i := range hooks {
+		h := hooks[i]
+		if reverse {
+			h = hooks[len(hooks)-1-i]
+		}
+		if err := h.run(ctx); err != nil {
+			err = fmt.Errorf("%s hook %q: %v", phase, h.Name, err)
+			if failFast {
+				return err
+			}
+			s.opts.logger.Printf("%v", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
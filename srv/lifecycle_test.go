@@ -0,0 +1,75 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+)
+
+func TestLifecycleHooksOrder(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	hook := func(name string) LifecycleHook {
+		return LifecycleHook{Name: name, Func: func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, name)
+			return nil
+		}}
+	}
+
+	s := New(
+		LogsWith(log.New(io.Discard, "", 0)),
+		EnablesSchemes(schema.SchemeHTTP),
+		WithListeners(&schema.HTTPFlg{Host: "127.0.0.1"}),
+		OnStart(hook("start-1"), hook("start-2")),
+		OnReady(hook("ready")),
+		OnStopping(hook("stopping-1"), hook("stopping-2")),
+		OnStopped(hook("stopped-1"), hook("stopped-2")),
+		OnShutdown(func() { calls = append(calls, "shutdown") }),
+	)
+	go func() { _ = s.Serve() }()
+	<-s.Ready()
+	_ = s.Shutdown()
+	<-s.Done()
+
+	want := "start-1,start-2,ready,stopping-2,stopping-1,stopped-2,stopped-1,shutdown"
+	if got := strings.Join(calls, ","); got != want {
+		t.Fatalf("wrong hook order: got %s want %s", got, want)
+	}
+}
+
+func TestFailingStartHookAborts(t *testing.T) {
+	app := &schema.HTTPFlg{Host: "127.0.0.1"}
+	s := New(
+		LogsWith(log.New(io.Discard, "", 0)),
+		EnablesSchemes(schema.SchemeHTTP),
+		WithListeners(app),
+		OnStart(LifecycleHook{Name: "db", Func: func(context.Context) error { return errors.New("unreachable") }}),
+	)
+	err := s.Serve()
+	if err == nil || !strings.Contains(err.Error(), `start hook "db": unreachable`) {
+		t.Fatalf("wrong error: got %v", err)
+	}
+	if _, err := net.Dial("tcp", s.Addrs()[schema.SchemeHTTP].String()); err == nil {
+		t.Fatal("listener still open after a failed start")
+	}
+}
+
+func TestLifecycleHookTimeout(t *testing.T) {
+	h := LifecycleHook{Name: "slow", Timeout: 10 * time.Millisecond, Func: func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}}
+	if err := h.run(context.Background()); err == nil {
+		t.Fatal("expected a timeout")
+	}
+}
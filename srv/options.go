@@ -15,6 +15,8 @@ type (
 
 	options struct {
 		EnabledListeners []string
+		// config replaces the package level flags, see Config.Options
+		config *Config
 
 		handler      http.Handler
 		systemHandler http.Handler
@@ -23,7 +25,17 @@ type (
 		logger    lg.Logger
 
 		hsts           *hstsConfig
-		onShutdown     func()
+		httpsRedirect  *redirectConfig
+		onShutdown     []func()
+		hooks          lifecycleHooks
+		cleanupTimeout  *time.Duration
+		maxHeaderSize   *ByteSize
+		shutdownTimeout *time.Duration
+		drainDelay      *time.Duration
+		onDraining      []func()
+		restartSignals []os.Signal
+		reload         *reloadConfig
+		signals        *SignalPolicy
 		listeners      []schema.ServerListener
 		systemListeners []schema.ServerListener
 	}
@@ -32,7 +44,6 @@ type (
 func newDefaultWithOptions(opts ...Option) *options {
 	o := &options{
 		EnabledListeners: enabledListeners,
-		onShutdown: func() {},
 		systemHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
 			_, _ = w.Write([]byte("OK"))
@@ -56,27 +67,132 @@ func LogsWith(l lg.Logger) Option {
 	}
 }
 
-// EnablesSchemes overrides the enabled schemes
+// EnablesSchemes overrides the enabled schemes (schema.SchemeHTTP, schema.SchemeHTTPS, schema.SchemeUnix)
 func EnablesSchemes(schemes ...string) Option {
 	return func(s *options) {
 		s.EnabledListeners = schemes
 	}
 }
 
-// OnShutdown runs the provided functions on shutdown
+// OnShutdown runs the provided functions once the server stopped, after the OnStopped hooks
 func OnShutdown(handlers ...func()) Option {
 	return func(s *options) {
-		if len(handlers) == 0 {
-			return
-		}
-		s.onShutdown = func() {
-			for _, run := range handlers {
-				run()
-			}
-		}
+		s.onShutdown = append(s.onShutdown, handlers...)
+	}
+}
+
+// OnStart runs the hooks in order once the listeners are bound, before serving. The server doesn't start
+// and closes its listeners if one of them fails.
+func OnStart(hooks ...LifecycleHook) Option {
+	return func(s *options) {
+		s.hooks.onStart = append(s.hooks.onStart, hooks...)
+	}
+}
+
+// OnReady runs the hooks in order once all listeners are serving, failures are logged
+func OnReady(hooks ...LifecycleHook) Option {
+	return func(s *options) {
+		s.hooks.onReady = append(s.hooks.onReady, hooks...)
+	}
+}
+
+// OnStopping runs the hooks in reverse order as soon as the shutdown starts, while still serving
+func OnStopping(hooks ...LifecycleHook) Option {
+	return func(s *options) {
+		s.hooks.onStopping = append(s.hooks.onStopping, hooks...)
+	}
+}
+
+// OnStopped runs the hooks in reverse order once the servers stopped
+func OnStopped(hooks ...LifecycleHook) Option {
+	return func(s *options) {
+		s.hooks.onStopped = append(s.hooks.onStopped, hooks...)
+	}
+}
+
+// WithCleanupTimeout overrides the cleanup-timeout flag, how long idle keep-alive connections are kept open
+func WithCleanupTimeout(timeout time.Duration) Option {
+	return func(s *options) {
+		s.cleanupTimeout = &timeout
+	}
+}
+
+// WithMaxHeaderSize overrides the max-header-size flag, the number of bytes read parsing the request header
+func WithMaxHeaderSize(bytes uint64) Option {
+	return func(s *options) {
+		s.maxHeaderSize = NewByteSize(bytes)
+	}
+}
+
+// WithShutdownTimeout overrides the shutdown-timeout flag, the time given to the requests in flight on shutdown
+// before the remaining connections are closed
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(s *options) {
+		s.shutdownTimeout = &timeout
 	}
 }
 
+// WithDrainDelay overrides the shutdown-drain-delay flag, how long to keep serving once the shutdown started
+func WithDrainDelay(delay time.Duration) Option {
+	return func(s *options) {
+		s.drainDelay = &delay
+	}
+}
+
+// OnDraining runs the provided functions as soon as the shutdown starts, before the drain delay
+func OnDraining(handlers ...func()) Option {
+	return func(s *options) {
+		s.onDraining = append(s.onDraining, handlers...)
+	}
+}
+
+// WithSignalPolicy configures the signals the server handles. Without it, SIGINT and SIGTERM shut down
+// gracefully and a second one closes all connections and exits the process.
+func WithSignalPolicy(policy SignalPolicy) Option {
+	return func(s *options) {
+		s.signals = &policy
+	}
+}
+
+// GracefulRestart hands all listeners over to a new copy of the binary when one of the signals is received
+// (SIGUSR2 by default), and shuts down once the new process is serving
+func GracefulRestart(signals ...os.Signal) Option {
+	if len(signals) == 0 {
+		signals = defaultRestartSignals
+	}
+	return func(s *options) {
+		s.restartSignals = signals
+	}
+}
+
+// ReloadsWith reloads the configuration when one of the signals is received (SIGHUP by default) or Reload
+// is called. Without a load function, the listeners only read their certificate files again.
+func ReloadsWith(load ReloadFunc, signals ...os.Signal) Option {
+	if len(signals) == 0 {
+		signals = defaultReloadSignals
+	}
+	return func(s *options) {
+		rc := s.reloadConfig()
+		rc.load = load
+		rc.signals = signals
+	}
+}
+
+// OnReload runs the hooks in order after each reload, to apply the settings of the handlers, like the log level
+func OnReload(hooks ...LifecycleHook) Option {
+	return func(s *options) {
+		rc := s.reloadConfig()
+		rc.hooks = append(rc.hooks, hooks...)
+	}
+}
+
+func (o *options) reloadConfig() *reloadConfig {
+	if o.reload == nil {
+		o.reload = &reloadConfig{signal: make(chan os.Signal, 1)}
+	}
+	return o.reload
+}
+
 // WithListeners replaces the default listeners with the provided listeres
 func WithListeners(listener schema.ServerListener, extra ...schema.ServerListener) Option {
 	all := append([]schema.ServerListener{listener}, extra...)
@@ -118,6 +234,8 @@ func WithSystem(handler http.Handler, listener schema.ServerListener, extra ...s
 	}
 }
 
+// EnableHSTS sends the Strict-Transport-Security header over TLS and redirects plain http requests to https.
+// Combine it with RedirectsToHTTPS to send them to the port of the https listener and keep some paths exempt.
 func EnableHSTS(maxAge time.Duration, sendPreload bool) Option {
 	if maxAge == 0 {
 		maxAge = time.Hour * 24 * 126 // 126 days (minimum for inclusion in the Chrome HSTS list)
@@ -130,6 +248,14 @@ func EnableHSTS(maxAge time.Duration, sendPreload bool) Option {
 	}
 }
 
+// RedirectsToHTTPS makes the http listeners answer only with redirects to the https listener,
+// except for ACME challenges and the exempt paths (a trailing slash matches a whole subtree), like health checks
+func RedirectsToHTTPS(exemptPaths ...string) Option {
+	return func(s *options) {
+		s.httpsRedirect = &redirectConfig{exempt: exemptPaths}
+	}
+}
+
 // Hooks allows for registering one or more hooks for the server to call during its lifecycle
 func Hooks(hook schema.Hook, extra ...schema.Hook) Option {
 	h := &compositeHook{
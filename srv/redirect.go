@@ -0,0 +1,70 @@
+package srv
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+)
+
+type redirectConfig struct {
+	exempt []string
+}
+
+// isExempt matches the path exactly, or as a prefix when the exempt path ends with a slash
+func (c *redirectConfig) isExempt(path string) bool {
+	for _, e := range c.exempt {
+		if path == e || strings.HasSuffix(e, "/") && strings.HasPrefix(path, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectHandler sends plain http requests to the same host on the TLS port, safe methods get a 301,
+// the others a 308 so clients repeat them with the same method and body
+func (c *redirectConfig) redirectHandler(tlsPort int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.isExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if tlsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(tlsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, target, code)
+	})
+}
+
+// tlsPort is the port of the first enabled https listener, where plain http requests get redirected
+func (s *defaultServer) tlsPort() (int, error) {
+	for _, l := range s.opts.listeners {
+		if l.Scheme() != schema.SchemeHTTPS || !s.hasScheme(l.Scheme()) {
+			continue
+		}
+		tl, err := l.Listener()
+		if err != nil {
+			return 0, err
+		}
+		_, port, err := schema.SplitHostPort(tl.Addr().String())
+		return port, err
+	}
+	return 0, errors.New("redirecting to https requires an enabled https listener")
+}
@@ -0,0 +1,40 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	c := &redirectConfig{exempt: []string{"/healthz", "/status/"}}
+
+	tests := []struct {
+		method   string
+		url      string
+		port     int
+		status   int
+		location string
+	}{
+		{http.MethodGet, "http://example.com:8080/a?b=c", 8443, http.StatusMovedPermanently, "https://example.com:8443/a?b=c"},
+		{http.MethodGet, "http://example.com/", 443, http.StatusMovedPermanently, "https://example.com/"},
+		{http.MethodPost, "http://[::1]:8080/form", 8443, http.StatusPermanentRedirect, "https://[::1]:8443/form"},
+		{http.MethodGet, "http://example.com/healthz", 8443, http.StatusTeapot, ""},
+		{http.MethodGet, "http://example.com/status/db", 8443, http.StatusTeapot, ""},
+		{http.MethodGet, "http://example.com/healthz/deep", 8443, http.StatusMovedPermanently, "https://example.com:8443/healthz/deep"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		c.redirectHandler(tt.port, app).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.url, nil))
+		if rec.Code != tt.status {
+			t.Fatalf("%s %s: wrong status: got %d want %d", tt.method, tt.url, rec.Code, tt.status)
+		}
+		if loc := rec.Header().Get("Location"); loc != tt.location {
+			t.Fatalf("%s %s: wrong location: got %s want %s", tt.method, tt.url, loc, tt.location)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+)
+
+// ReloadFunc reads the configuration again, from the flags, the environment or a config file, and returns
+// the listeners it describes. They are matched to the running ones by name, and never bound themselves.
+type ReloadFunc func(ctx context.Context) ([]schema.ServerListener, error)
+
+type reloadConfig struct {
+	mu      sync.Mutex
+	load    ReloadFunc
+	signals []os.Signal
+	hooks   []LifecycleHook
+	signal  chan os.Signal
+}
+
+// Reload reads the configuration with the function passed to ReloadsWith, applies what can change while serving
+// (timeouts, listen limits, certificates) and logs the differences, the ones requiring a restart included.
+// Nothing is applied when the configuration fails to load or validate. The OnReload hooks run last.
+func (s *defaultServer) Reload(ctx context.Context) error {
+	rc := s.opts.reload
+	if rc == nil {
+		return errors.New("reloading is not enabled")
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	current := s.activeListeners()
+	next := current
+	if rc.load != nil {
+		loaded, err := rc.load(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load the configuration: %v", err)
+		}
+		next = loaded
+	}
+
+	byName := make(map[string]schema.ServerListener, len(next))
+	for _, l := range next {
+		byName[listenerName(l)] = l
+	}
+
+	type pending struct {
+		current schema.Reloadable
+		next    schema.ServerListener
+	}
+	var (
+		changes []schema.Change
+		apply   []pending
+	)
+	for _, l := range current {
+		name := listenerName(l)
+		n, ok := byName[name]
+		if !ok {
+			changes = append(changes, schema.Change{Listener: name, Setting: "Listener", From: l.Scheme(), To: "removed", Restart: true})
+			continue
+		}
+		delete(byName, name)
+
+		r, ok := l.(schema.Reloadable)
+		if !ok {
+			// the listener can't change while serving, anything different waits for a restart
+			if l != n && l.String() != n.String() {
+				changes = append(changes, schema.Change{Listener: name, Setting: "Listener", From: l.String(), To: n.String(), Restart: true})
+			}
+			continue
+		}
+		diff, err := r.Diff(n)
+		if err != nil {
+			return fmt.Errorf("invalid configuration for %s: %v", name, err)
+		}
+		changes = append(changes, diff...)
+		apply = append(apply, pending{current: r, next: n})
+	}
+	for _, l := range next {
+		if _, added := byName[listenerName(l)]; added {
+			changes = append(changes, schema.Change{Listener: listenerName(l), Setting: "Listener", From: "none", To: l.Scheme(), Restart: true})
+		}
+	}
+
+	// every listener validated the configuration and loaded its files above, so a reload isn't left half applied
+	var errs []error
+	for _, p := range apply {
+		if err := p.current.Apply(p.next); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	errs = append(errs, s.runHooks(ctx, "reload", rc.hooks, false, false))
+
+	if len(changes) == 0 {
+		s.opts.logger.Printf("Reloaded configuration, no changes")
+	} else {
+		s.opts.logger.Printf("Reloaded configuration, %d changes:", len(changes))
+		for _, c := range changes {
+			s.opts.logger.Printf("  %s", c)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReloadHandler reloads the configuration on POST, for an admin endpoint
+func (s *defaultServer) ReloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		s.opts.logger.Printf("Reload requested from %s", r.RemoteAddr)
+		if err := s.Reload(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// handleReload reloads the configuration on each reload signal until the server shuts down
+func (s *defaultServer) handleReload() {
+	rc := s.opts.reload
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case sig := <-rc.signal:
+			s.opts.logger.Printf("Received %s, reloading the configuration... ", sig)
+		}
+		if err := s.Reload(context.Background()); err != nil {
+			s.opts.logger.Printf("Reload failed, keeping the current configuration: %v", err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package srv
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+)
+
+func TestReloadAppliesLiveSettings(t *testing.T) {
+	var logs bytes.Buffer
+	app := &schema.HTTPFlg{Prefix: "app", Host: "127.0.0.1", WriteTimeout: time.Minute}
+	var reloaded bool
+	s := New(
+		LogsWith(log.New(&logs, "", 0)),
+		EnablesSchemes(schema.SchemeHTTP),
+		WithListeners(app),
+		WithSignalPolicy(SignalPolicy{Disabled: true}),
+		HandlesRequestsWith(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			_, _ = w.Write([]byte("slow"))
+		})),
+		ReloadsWith(func(context.Context) ([]schema.ServerListener, error) {
+			return []schema.ServerListener{
+				&schema.HTTPFlg{Prefix: "app", Host: "127.0.0.1", Port: 1, WriteTimeout: 50 * time.Millisecond},
+			}, nil
+		}),
+		OnReload(LifecycleHook{Name: "log-level", Func: func(context.Context) error {
+			reloaded = true
+			return nil
+		}}),
+	)
+	go func() { _ = s.Serve() }()
+	<-s.Ready()
+	defer func() {
+		_ = s.Shutdown()
+		<-s.Done()
+	}()
+
+	url := "http://" + s.Addrs()["app"].String() + "/"
+	get := func() error {
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+	if err := get(); err != nil {
+		t.Fatalf("request failed before the reload: %v", err)
+	}
+
+	if err := s.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded {
+		t.Fatal("reload hook not called")
+	}
+	for _, want := range []string{
+		"app WriteTimeout: 1m0s -> 50ms",
+		"app Address: 127.0.0.1:0 -> 127.0.0.1:1 (requires restart)",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("missing change %q in logs:\n%s", want, logs.String())
+		}
+	}
+	if err := get(); err == nil {
+		t.Fatal("expected the new write timeout to cut the response")
+	}
+}
+
+func TestReloadKeepsConfigurationOnError(t *testing.T) {
+	app := &schema.HTTPFlg{Host: "127.0.0.1", ListenLimit: 5}
+	s := New(
+		LogsWith(log.New(io.Discard, "", 0)),
+		EnablesSchemes(schema.SchemeHTTP),
+		WithListeners(app),
+		ReloadsWith(func(context.Context) ([]schema.ServerListener, error) {
+			return []schema.ServerListener{&schema.HTTPFlg{Host: "127.0.0.1", ListenLimit: -1}}, nil
+		}),
+	)
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.(*defaultServer).closeListeners()
+
+	if err := s.Reload(context.Background()); err == nil {
+		t.Fatal("expected an invalid configuration")
+	}
+	if app.ListenLimit != 5 {
+		t.Fatalf("wrong listen limit: got %d want %d", app.ListenLimit, 5)
+	}
+
+	if err := New(LogsWith(log.New(io.Discard, "", 0))).Reload(context.Background()); err == nil {
+		t.Fatal("expected an error without ReloadsWith")
+	}
+}
+
+func TestReloadFromConfigFile(t *testing.T) {
+	path := writeConfig(t, "srv.yaml", `
+listeners:
+  - name: app
+    host: 127.0.0.1
+    write-timeout: 1m
+`)
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := cfg.Options(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	s := New(append(opts,
+		LogsWith(log.New(&logs, "", 0)),
+		EnablesSchemes(schema.SchemeHTTP),
+		ReloadsWith(cfg.Reloader(path, nil)),
+	)...)
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.(*defaultServer).closeListeners()
+
+	writeFile := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("listeners:\n  - name: app\n    host: 127.0.0.1\n    write-timeout: 50ms\n")
+	if err := s.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := "app WriteTimeout: 1m0s -> 50ms"; !strings.Contains(logs.String(), want) {
+		t.Fatalf("missing change %q in logs:\n%s", want, logs.String())
+	}
+
+	writeFile("listeners:\n  - name: app\n    port: 70000\n")
+	if err := s.Reload(context.Background()); err == nil || !strings.Contains(err.Error(), "port") {
+		t.Fatalf("expected the invalid file to be rejected: got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+)
+
+// envReadyFd names the descriptor a restarted process writes to once all its listeners are serving
+const envReadyFd = "GO_SRV_READY_FD"
+
+// restartReadyTimeout bounds the wait for the new process, the old one keeps serving when it expires
+const restartReadyTimeout = 30 * time.Second
+
+func (s *defaultServer) handleRestart() {
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case <-s.restart:
+		}
+		s.opts.logger.Printf("Handing listeners over to a new process... ")
+		if err := s.handoff(); err != nil {
+			s.opts.logger.Printf("graceful restart failed, still serving: %v", err)
+			continue
+		}
+		s.opts.logger.Printf("New process is serving, shutting down... ")
+		if err := s.Shutdown(); err != nil {
+			s.opts.logger.Printf("error during server shutdown: %v", err)
+		}
+		return
+	}
+}
+
+// handoff starts a new copy of the binary with all the listening sockets and blocks until it serves
+func (s *defaultServer) handoff() error {
+	var (
+		files []*os.File
+		names []string
+	)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	for _, server := range s.activeListeners() {
+		l, err := server.Listener()
+		if err != nil {
+			return err
+		}
+		f, err := schema.ListenerFile(l)
+		if err != nil {
+			return fmt.Errorf("%s: %v", listenerName(server), err)
+		}
+		files = append(files, f)
+		names = append(names, listenerName(server))
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		_ = readyW.Close()
+		return err
+	}
+
+	// the new process finds the sockets the same way as with systemd socket activation
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(append([]*os.File{}, files...), readyW)
+	cmd.Env = append(restartEnv(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		envReadyFd+"="+strconv.Itoa(3+len(files)),
+	)
+
+	err = cmd.Start()
+	_ = readyW.Close()
+	if err != nil {
+		return err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		_, rerr := readyR.Read(make([]byte, 1))
+		ready <- rerr
+	}()
+
+	select {
+	case rerr := <-ready:
+		if rerr == nil {
+			s.opts.logger.Printf("New process %d is ready", cmd.Process.Pid)
+			go func() { _ = cmd.Wait() }()
+			return nil
+		}
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return errors.New("new process exited before it was ready")
+	case <-time.After(restartReadyTimeout):
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return fmt.Errorf("new process wasn't ready after %s", restartReadyTimeout)
+	}
+}
+
+// activeListeners are the listeners Serve starts, regular ones for the enabled schemes and all system ones
+func (s *defaultServer) activeListeners() []schema.ServerListener {
+	var all []schema.ServerListener
+	for _, l := range s.opts.listeners {
+		if s.hasScheme(l.Scheme()) {
+			all = append(all, l)
+		}
+	}
+	return append(all, s.opts.systemListeners...)
+}
+
+// notifyReady tells the process that handed off its listeners to us that we're serving
+func notifyReady() {
+	v := os.Getenv(envReadyFd)
+	if v == "" {
+		return
+	}
+	_ = os.Unsetenv(envReadyFd)
+
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	_, _ = f.Write([]byte{1})
+	_ = f.Close()
+}
+
+func listenerName(l schema.ServerListener) string {
+	if n, ok := l.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return l.Scheme()
+}
+
+// restartEnv is the current environment without the socket handoff variables of a previous start
+func restartEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", envReadyFd:
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package srv
+
+import "os"
+
+// there is no conventional restart signal outside of unix, it has to be passed to GracefulRestart
+var defaultRestartSignals []os.Signal
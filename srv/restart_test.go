@@ -0,0 +1,187 @@
+//go:build unix
+
+package srv
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+)
+
+// TestGracefulRestartHelper is the server of TestGracefulRestart, it runs in the process started by the test
+// and again in the one it hands its listeners over to
+func TestGracefulRestartHelper(t *testing.T) {
+	dir := os.Getenv("GO_SRV_RESTART_DIR")
+	if dir == "" {
+		t.Skip("only runs as a child of TestGracefulRestart")
+	}
+	handedOff := os.Getenv("LISTEN_FDS") != ""
+	pid := strconv.Itoa(os.Getpid())
+
+	s := New(
+		LogsWith(log.New(io.Discard, "", 0)),
+		EnablesSchemes(schema.SchemeHTTP, schema.SchemeUnix),
+		WithListeners(
+			&schema.HTTPFlg{Prefix: "app", Host: "127.0.0.1"},
+			&schema.UnixFlg{Prefix: "sock", Path: filepath.Join(dir, "srv.sock")},
+		),
+		GracefulRestart(syscall.SIGUSR2),
+		HandlesRequestsWith(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(pid))
+		})),
+		OnDraining(func() { writeMarker(t, dir, "drained-"+pid, pid) }),
+	)
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	if handedOff {
+		writeMarker(t, dir, "new", pid)
+		// hold the inherited sockets without serving them for a while, the old process has to keep serving
+		time.Sleep(500 * time.Millisecond)
+	} else {
+		writeMarker(t, dir, "addr", s.Addrs()["app"].String())
+	}
+	if err := s.Serve(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGracefulRestart(t *testing.T) {
+	dir := t.TempDir()
+	out, err := os.Create(filepath.Join(dir, "out.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestGracefulRestartHelper$", "-test.v")
+	cmd.Env = append(os.Environ(), "GO_SRV_RESTART_DIR="+dir)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		if pid, err := strconv.Atoi(readMarker(dir, "new")); err == nil {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+		}
+		if t.Failed() {
+			logs, _ := os.ReadFile(out.Name())
+			t.Logf("servers output:\n%s", logs)
+		}
+	})
+
+	addr := waitMarker(t, dir, "addr")
+	sock := filepath.Join(dir, "srv.sock")
+	oldPid := strconv.Itoa(cmd.Process.Pid)
+	assertServedBy(t, addr, sock, oldPid)
+
+	if err := cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	newPid := waitMarker(t, dir, "new")
+	if newPid == oldPid {
+		t.Fatal("listeners weren't handed over to a new process")
+	}
+
+	// the new process has the sockets but isn't ready yet
+	if readMarker(dir, "drained-"+oldPid) != "" {
+		t.Fatal("old process drained before the new one was ready")
+	}
+	select {
+	case err := <-exited:
+		t.Fatalf("old process exited before the new one was ready: %v", err)
+	default:
+	}
+	assertServedBy(t, addr, sock, oldPid)
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatalf("old process failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("old process didn't shut down once the new one was ready")
+	}
+	if readMarker(dir, "drained-"+oldPid) == "" {
+		t.Fatal("old process didn't drain")
+	}
+	assertServedBy(t, addr, sock, newPid)
+
+	pid, _ := strconv.Atoi(newPid)
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	waitMarker(t, dir, "drained-"+newPid)
+}
+
+// assertServedBy checks that the process answering on both the tcp and the unix socket is pid
+func assertServedBy(t *testing.T, addr, sock, pid string) {
+	t.Helper()
+	tcp := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	unix := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", sock)
+		},
+	}}
+	for name, get := range map[string]func() (*http.Response, error){
+		"tcp":  func() (*http.Response, error) { return tcp.Get("http://" + addr + "/") },
+		"unix": func() (*http.Response, error) { return unix.Get("http://unix/") },
+	} {
+		resp, err := get()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != pid {
+			t.Fatalf("wrong process serving %s: got %s want %s", name, body, pid)
+		}
+	}
+}
+
+func writeMarker(t *testing.T, dir, name, value string) {
+	t.Helper()
+	// rename, so the marker is never read half written
+	tmp := filepath.Join(dir, name+".tmp")
+	if err := os.WriteFile(tmp, []byte(value), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readMarker(dir, name string) string {
+	b, _ := os.ReadFile(filepath.Join(dir, name))
+	return string(b)
+}
+
+func waitMarker(t *testing.T, dir, name string) string {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if v := readMarker(dir, name); v != "" {
+			return v
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
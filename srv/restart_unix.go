@@ -0,0 +1,10 @@
+//go:build unix
+
+package srv
+
+import (
+	"os"
+	"syscall"
+)
+
+var defaultRestartSignals = []os.Signal{syscall.SIGUSR2}
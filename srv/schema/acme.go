@@ -0,0 +1,100 @@
+package schema
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEFlg configures certificates obtained and renewed through ACME, e.g. from Let's Encrypt
+type ACMEFlg struct {
+	Enabled bool
+	// DirectoryURL defaults to the Let's Encrypt production directory
+	DirectoryURL string
+	// DirectoryCA is a CA bundle to trust for the directory, e.g. for a local Pebble instance
+	DirectoryCA string
+	// CacheDir keeps the account key and certificates across restarts, they are kept in memory when empty
+	CacheDir string
+	// Hosts the certificates may be requested for
+	Hosts []string
+	Email string
+}
+
+// acmeManager creates the certificate manager once, it's shared by the TLS listener and the http-01 challenge handler
+func (t *TLSFlg) acmeManager() (*autocert.Manager, error) {
+	t.acmeOnce.Do(func() {
+		t.acme, t.acmeErr = t.ACME.manager(t.Prefix)
+	})
+	return t.acme, t.acmeErr
+}
+
+func (a *ACMEFlg) manager(prefix string) (*autocert.Manager, error) {
+	if len(a.Hosts) == 0 {
+		return nil, fmt.Errorf("the required flag %q was not specified", FlagName(prefix, "tls-acme-host"))
+	}
+
+	client := &acme.Client{DirectoryURL: a.DirectoryURL}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if a.DirectoryCA != "" {
+		pool, err := loadCertPool(a.DirectoryCA)
+		if err != nil {
+			return nil, err
+		}
+		client.HTTPClient = &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+			},
+		}
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(a.Hosts...),
+		Email:      a.Email,
+		Client:     client,
+	}
+	if a.CacheDir != "" {
+		m.Cache = autocert.DirCache(a.CacheDir)
+	}
+	return m, nil
+}
+
+// HTTPChallengeHandler answers the ACME http-01 challenges for this listener and passes everything else to next.
+// The server wires it into its plain http listeners, and doesn't start when the ACME settings are invalid.
+func (t *TLSFlg) HTTPChallengeHandler(next http.Handler) (http.Handler, error) {
+	if !t.ACME.Enabled {
+		return next, nil
+	}
+	m, err := t.acmeManager()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", t.Name(), err)
+	}
+	return m.HTTPHandler(next), nil
+}
+
+// enableACME serves the managed certificates, with the static ones as a fallback for hosts ACME doesn't manage.
+// It also answers tls-alpn-01 challenges on the TLS listener.
+func (t *TLSFlg) enableACME(cfg *tls.Config, static *certReloader) error {
+	m, err := t.acmeManager()
+	if err != nil {
+		return err
+	}
+
+	cfg.NextProtos = append(cfg.NextProtos, acme.ALPNProto)
+	cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := m.GetCertificate(hello)
+		if err != nil && static != nil && static.state().certs != nil {
+			return static.GetCertificate(hello)
+		}
+		return cert, err
+	}
+	return nil
+}
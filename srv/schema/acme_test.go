@@ -0,0 +1,132 @@
+package schema
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestACMERequiresHosts(t *testing.T) {
+	tf := &TLSFlg{ACME: ACMEFlg{Enabled: true}}
+	if _, err := tf.acmeManager(); err == nil {
+		t.Fatal("expected an error without a host whitelist")
+	}
+}
+
+func TestHTTPChallengeHandler(t *testing.T) {
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("app"))
+	})
+
+	tf := &TLSFlg{ACME: ACMEFlg{Enabled: true, Hosts: []string{"example.com"}}}
+	h, err := tf.HTTPChallengeHandler(fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newTestRequest(t, "http://example.com/hello"))
+	if rr.Body.String() != "app" {
+		t.Fatalf("regular request was not passed on: got %q", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, newTestRequest(t, "http://example.com/.well-known/acme-challenge/unknown"))
+	if rr.Body.String() == "app" {
+		t.Fatal("challenge request was passed on to the app")
+	}
+
+	disabled, err := (&TLSFlg{}).HTTPChallengeHandler(fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	disabled.ServeHTTP(rr, newTestRequest(t, "http://example.com/.well-known/acme-challenge/unknown"))
+	if rr.Body.String() != "app" {
+		t.Fatal("challenge handler should be a no-op when ACME is disabled")
+	}
+}
+
+func TestHTTPChallengeHandlerInvalidSettings(t *testing.T) {
+	tf := &TLSFlg{ACME: ACMEFlg{Enabled: true}}
+	if _, err := tf.HTTPChallengeHandler(http.NotFoundHandler()); err == nil {
+		t.Fatal("expected an error without a host whitelist")
+	}
+}
+
+func TestACMEALPNChallenge(t *testing.T) {
+	// the certificate of a pending tls-alpn-01 challenge, where the manager keeps it
+	cacheDir := t.TempDir()
+	ca, caKey, err := newDevCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM, err := newDevLeaf(ca, caKey, []string{"example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, "example.com+token"), append(keyPEM, certPEM...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tf := &TLSFlg{HTTPFlg: HTTPFlg{Host: "127.0.0.1"}, ACME: ACMEFlg{Enabled: true, CacheDir: cacheDir, Hosts: []string{"example.com"}}}
+	eg := new(errgroup.Group)
+	hs, err := tf.Serve(ServerConfig{Logger: log.New(io.Discard, "", 0), Handler: http.NotFoundHandler()}, eg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = hs.Close()
+		_ = eg.Wait()
+	}()
+
+	conn, err := tls.Dial("tcp", tf.listener.Addr().String(), &tls.Config{
+		ServerName:         "example.com",
+		NextProtos:         []string{acme.ALPNProto},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != acme.ALPNProto {
+		t.Fatalf("wrong protocol: got %q want %q", state.NegotiatedProtocol, acme.ALPNProto)
+	}
+	if !bytes.Equal(state.PeerCertificates[0].Raw, pemDER(t, certPEM)) {
+		t.Fatal("the challenge certificate wasn't served")
+	}
+	// let the server finish the handshake before closing
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _ = conn.Read(make([]byte, 1))
+}
+
+func pemDER(t *testing.T, b []byte) []byte {
+	t.Helper()
+	block, _ := pem.Decode(b)
+	if block == nil {
+		t.Fatal("no PEM block")
+	}
+	return block.Bytes
+}
+
+func newTestRequest(t *testing.T, url string) *http.Request {
+	t.Helper()
+	r, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
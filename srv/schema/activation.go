@@ -0,0 +1,87 @@
+package schema
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFdsStart is the first file descriptor passed by the service manager (SD_LISTEN_FDS_START)
+const listenFdsStart = 3
+
+var (
+	inheritedOnce sync.Once
+	inheritedMu   sync.Mutex
+	inherited     map[string][]net.Listener
+)
+
+// loadInherited picks up the sockets passed through LISTEN_FDS/LISTEN_FDNAMES by systemd socket activation.
+// The environment is cleared afterwards, so it doesn't leak into child processes.
+func loadInherited() {
+	inherited = make(map[string][]net.Listener)
+
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+
+	var names []string
+	if v := os.Getenv("LISTEN_FDNAMES"); v != "" {
+		names = strings.Split(v, ":")
+	}
+
+	for i := 0; i < n; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+		l, err := net.FileListener(f)
+		_ = f.Close() // FileListener works on a dup of the descriptor
+		if err != nil {
+			// not a stream socket, nothing we can serve http on
+			continue
+		}
+		inherited[name] = append(inherited[name], l)
+	}
+}
+
+// InheritedListener returns a pre-opened socket passed by the service manager under one of the given names.
+// The names are matched against LISTEN_FDNAMES (FileDescriptorName= in the systemd .socket unit),
+// a listener can only be claimed once.
+func InheritedListener(names ...string) (net.Listener, bool) {
+	inheritedOnce.Do(loadInherited)
+
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if ls := inherited[name]; len(ls) > 0 {
+			inherited[name] = ls[1:]
+			return ls[0], true
+		}
+	}
+	return nil, false
+}
+
+// listen uses an inherited socket matching one of the names when there is one, and binds the address otherwise
+func listen(network, address string, names ...string) (net.Listener, error) {
+	if l, ok := InheritedListener(names...); ok {
+		return l, nil
+	}
+	return net.Listen(network, address)
+}
@@ -0,0 +1,70 @@
+package schema
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+// TestActivationHelper runs in the child process started by TestSocketActivation
+func TestActivationHelper(t *testing.T) {
+	want := os.Getenv("GO_SRV_ACTIVATION_PORT")
+	if want == "" {
+		t.Skip("only runs as a child of TestSocketActivation")
+	}
+
+	h := &HTTPFlg{Prefix: "app", Host: "localhost", Port: 0}
+	if _, err := h.Listener(); err != nil {
+		t.Fatal(err)
+	}
+	if strconv.Itoa(h.Port) != want {
+		t.Fatalf("listener was not inherited: got port %d want %s", h.Port, want)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Fatal("LISTEN_FDS should be cleared after activation")
+	}
+}
+
+func TestSocketActivation(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, port, err := SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestActivationHelper$", "-test.v")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(),
+		"GO_SRV_ACTIVATION_PORT="+strconv.Itoa(port),
+		"LISTEN_FDS=1",
+		"LISTEN_FDNAMES=app",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("child process failed: %v\n%s", err, out)
+	}
+}
+
+func TestNoActivationFallsBack(t *testing.T) {
+	h := &HTTPFlg{Prefix: "no-such-socket", Host: "127.0.0.1", Port: 0}
+	l, err := h.Listener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if h.Port == 0 {
+		t.Fatal("expected a random port to be bound")
+	}
+}
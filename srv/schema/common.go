@@ -1,23 +1,130 @@
 package schema
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"os"
 	"strconv"
+	"sync"
 )
 
 const (
 	SchemeHTTP  = "http"
 	SchemeHTTPS = "https"
+	SchemeUnix  = "unix"
 )
 
-func prefixer(prefix, flagName string) string {
+// FlagName is the name of a flag registered with a prefix, like app-port for the port flag of the app listener
+func FlagName(prefix, flagName string) string {
 	if prefix == "" {
 		return flagName
 	}
 	return prefix + "-" + flagName
 }
 
+func listenerName(prefix, scheme string) string {
+	if prefix == "" {
+		return scheme
+	}
+	return prefix
+}
+
+// limitedListener accepts at most limit connections at a time, like netutil.LimitListener, but the limit can
+// change while serving and zero means unlimited. It keeps a handle on the socket, so it can still be handed off.
+type limitedListener struct {
+	net.Listener
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+	closed bool
+}
+
+func limitListener(l net.Listener, n int) *limitedListener {
+	ll := &limitedListener{Listener: l, limit: n}
+	ll.cond = sync.NewCond(&ll.mu)
+	return ll
+}
+
+func (l *limitedListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	for l.limit > 0 && l.active >= l.limit && !l.closed {
+		l.cond.Wait()
+	}
+	l.active++
+	l.mu.Unlock()
+
+	c, err := l.Listener.Accept()
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	return &limitedConn{Conn: c, release: l.release}, nil
+}
+
+func (l *limitedListener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.cond.Broadcast()
+	l.mu.Unlock()
+	return l.Listener.Close()
+}
+
+func (l *limitedListener) setLimit(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = n
+	l.cond.Broadcast()
+}
+
+func (l *limitedListener) getLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+func (l *limitedListener) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.cond.Signal()
+}
+
+func (l *limitedListener) File() (*os.File, error) {
+	return ListenerFile(l.Listener)
+}
+
+type limitedConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
+
+// ListenerFile returns a duplicate of the socket behind the listener, to be passed to another process.
+// Unix sockets handed off this way are no longer removed when the listener closes, the new owner keeps using them.
+func ListenerFile(l net.Listener) (*os.File, error) {
+	fl, ok := l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, errors.New("listener does not wrap a socket that can be handed off")
+	}
+	f, err := fl.File()
+	if err != nil {
+		return nil, err
+	}
+	if ul, ok := l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	return f, nil
+}
+
 func SplitHostPort(addr string) (host string, port int, err error) {
 	host, portStr, err := net.SplitHostPort(addr)
 	if err != nil {
@@ -0,0 +1,206 @@
+package schema
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type connRegistryKey struct{}
+type connKey struct{}
+
+// ConnRegistry tracks the connections of the servers it is attached to, and the long-lived streams
+// handlers register, so both can be closed on shutdown. http.Server.Shutdown ignores hijacked connections
+// and waits for streaming handlers which don't know they should stop, the connections of the streams
+// registered with TrackStream are closed once the grace period is over.
+type ConnRegistry struct {
+	mu       sync.Mutex
+	conns    map[net.Conn]http.ConnState
+	streams  map[*stream]struct{}
+	stopping bool
+}
+
+type stream struct {
+	conn   net.Conn
+	cancel context.CancelFunc
+}
+
+func NewConnRegistry() *ConnRegistry {
+	return &ConnRegistry{
+		conns:   make(map[net.Conn]http.ConnState),
+		streams: make(map[*stream]struct{}),
+	}
+}
+
+// attach tracks the connections of the server, chaining the ConnState and ConnContext already configured.
+// It returns the listener to serve, its connections are forgotten once closed, even when they were hijacked.
+func (r *ConnRegistry) attach(srv *http.Server, l net.Listener) net.Listener {
+	if r == nil {
+		return l
+	}
+
+	connState := srv.ConnState
+	srv.ConnState = func(c net.Conn, st http.ConnState) {
+		r.setState(c, st)
+		if connState != nil {
+			connState(c, st)
+		}
+	}
+
+	connContext := srv.ConnContext
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, c)
+		}
+		ctx = context.WithValue(ctx, connRegistryKey{}, r)
+		return context.WithValue(ctx, connKey{}, connOf(c))
+	}
+	return &trackedListener{Listener: l, reg: r}
+}
+
+func (r *ConnRegistry) setState(c net.Conn, st http.ConnState) {
+	c = connOf(c)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if st == http.StateClosed {
+		delete(r.conns, c)
+		return
+	}
+	r.conns[c] = st
+}
+
+func (r *ConnRegistry) forget(c net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, c)
+}
+
+// connOf is the accepted connection, under the TLS one the server sees
+func connOf(c net.Conn) net.Conn {
+	if tc, ok := c.(*tls.Conn); ok {
+		return tc.NetConn()
+	}
+	return c
+}
+
+// trackedListener lets the registry know when its connections get closed, http.Server doesn't for hijacked ones
+type trackedListener struct {
+	net.Listener
+	reg *ConnRegistry
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &trackedConn{Conn: c, reg: l.reg}, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	reg       *ConnRegistry
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { c.reg.forget(c) })
+	return err
+}
+
+// Counts returns the number of open connections by state, hijacked ones are counted until they are closed
+func (r *ConnRegistry) Counts() map[http.ConnState]int {
+	counts := make(map[http.ConnState]int)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, st := range r.conns {
+		counts[st]++
+	}
+	return counts
+}
+
+// Streams returns the number of long-lived streams in progress
+func (r *ConnRegistry) Streams() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.streams)
+}
+
+// TrackStream registers a long-lived stream, like server-sent events or a websocket, for the request.
+// The returned context is cancelled when the server shuts down, the handler should then wrap up and call done.
+// A connection hijacked by the request is closed if the stream doesn't end before the shutdown timeout.
+// Outside of a server with a registry, it returns the request context.
+func TrackStream(r *http.Request) (ctx context.Context, done func()) {
+	reg, ok := r.Context().Value(connRegistryKey{}).(*ConnRegistry)
+	if !ok {
+		return r.Context(), func() {}
+	}
+	conn, _ := r.Context().Value(connKey{}).(net.Conn)
+	return reg.track(r.Context(), conn)
+}
+
+func (r *ConnRegistry) track(parent context.Context, conn net.Conn) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	s := &stream{conn: conn, cancel: cancel}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopping {
+		cancel()
+		return ctx, func() {}
+	}
+	r.streams[s] = struct{}{}
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			cancel()
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			delete(r.streams, s)
+		})
+	}
+}
+
+// Shutdown cancels the streams and waits for them to end until the context is done, then closes the
+// connections of the streams still running. The other hijacked connections, like the HTTP/2 ones of h2c
+// which get a GOAWAY from http.Server.Shutdown, are left to their owner.
+func (r *ConnRegistry) Shutdown(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	r.stopping = true
+	for s := range r.streams {
+		s.cancel()
+	}
+	r.mu.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for r.Streams() > 0 {
+		select {
+		case <-ctx.Done():
+			var conns []net.Conn
+			r.mu.Lock()
+			for s := range r.streams {
+				if s.conn != nil {
+					conns = append(conns, s.conn)
+				}
+			}
+			r.mu.Unlock()
+			// closing forgets the connection, which takes the lock
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package schema
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/sync/errgroup"
+)
+
+func startTracked(t *testing.T, h http.HandlerFunc) (*ConnRegistry, *httptest.Server) {
+	t.Helper()
+	reg := NewConnRegistry()
+	ts := httptest.NewUnstartedServer(h)
+	ts.Listener = reg.attach(ts.Config, ts.Listener)
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return reg, ts
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConnRegistryCancelsStreams(t *testing.T) {
+	reg, ts := startTracked(t, func(w http.ResponseWriter, r *http.Request) {
+		ctx, done := TrackStream(r)
+		defer done()
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-ctx.Done()
+	})
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	waitFor(t, "the stream", func() bool { return reg.Streams() == 1 })
+	if n := reg.Counts()[http.StateActive]; n != 1 {
+		t.Fatalf("wrong active connections: got %d want %d", n, 1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := reg.Shutdown(ctx); err != nil {
+		t.Fatalf("streams didn't end when cancelled: %v", err)
+	}
+
+	// streams registered while stopping are cancelled right away
+	sctx, _ := reg.track(context.Background(), nil)
+	if sctx.Err() == nil {
+		t.Fatal("stream started during shutdown wasn't cancelled")
+	}
+}
+
+func TestConnRegistryClosesHijacked(t *testing.T) {
+	reg, ts := startTracked(t, func(w http.ResponseWriter, r *http.Request) {
+		_, done := TrackStream(r)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			done()
+			return
+		}
+		// a stubborn stream which ignores the cancellation
+		go func() {
+			defer done()
+			_, _ = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+			_, _ = bufio.NewReader(conn).ReadByte()
+		}()
+	})
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the hijacked connection", func() bool { return reg.Counts()[http.StateHijacked] == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := reg.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("wrong error: got %v want %v", err, context.DeadlineExceeded)
+	}
+	waitFor(t, "the stream to end", func() bool { return reg.Streams() == 0 })
+	if n := reg.Counts()[http.StateHijacked]; n != 0 {
+		t.Fatalf("hijacked connections left open: %d", n)
+	}
+}
+
+func TestConnRegistryForgetsClosedHijacked(t *testing.T) {
+	reg, ts := startTracked(t, func(w http.ResponseWriter, r *http.Request) {
+		// a WebSocket like handler which doesn't register a stream
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_, _ = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+			_, _ = bufio.NewReader(conn).ReadByte()
+		}()
+	})
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the hijacked connection", func() bool { return reg.Counts()[http.StateHijacked] == 1 })
+
+	// the handler closes the connection once it reads a byte
+	if _, err := conn.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the closed connection to be forgotten", func() bool { return reg.Counts()[http.StateHijacked] == 0 })
+}
+
+func TestConnRegistryShutdownKeepsH2C(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := &HTTPFlg{Host: "127.0.0.1", H2C: true}
+	reg := NewConnRegistry()
+	eg := new(errgroup.Group)
+	hs, err := h.Serve(ServerConfig{
+		Logger: log.New(io.Discard, "", 0),
+		Conns:  reg,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			_, _ = w.Write([]byte("done"))
+		}),
+	}, eg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, network, addr)
+		},
+	}}
+	type result struct {
+		body string
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		resp, err := client.Get("http://" + h.listener.Addr().String() + "/")
+		if err != nil {
+			res <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		res <- result{body: string(body), err: err}
+	}()
+	<-started
+	waitFor(t, "the h2c connection", func() bool { return reg.Counts()[http.StateHijacked] == 1 })
+
+	// the grace period of the registry expires while the h2c request is still in flight
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := hs.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Shutdown(ctx); err != nil {
+		t.Fatalf("no stream was registered: %v", err)
+	}
+	<-ctx.Done()
+	close(release)
+
+	r := <-res
+	if r.err != nil {
+		t.Fatalf("in flight h2c request failed: %v", r.err)
+	}
+	if r.body != "done" {
+		t.Fatalf("wrong body: got %s want %s", r.body, "done")
+	}
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
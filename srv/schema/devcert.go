@@ -0,0 +1,204 @@
+package schema
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	devCAFile   = "ca.crt"
+	devCAKey    = "ca.key"
+	devCertFile = "tls.crt"
+	devCertKey  = "tls.key"
+
+	devCAValidity   = 365 * 24 * time.Hour
+	devCertValidity = 30 * 24 * time.Hour
+)
+
+// devCertificate creates a self-signed CA and a leaf certificate for local development.
+// Both are kept in the directory, by default one per listener in the user cache directory, and reused
+// across restarts as long as the leaf still covers the hosts, so clients are told to trust the CA only once.
+func devCertificate(dir, prefix string, hosts ...string) (*tls.Certificate, string, error) {
+	var names []string
+	for _, h := range append(hosts, "localhost", "127.0.0.1", "::1") {
+		// a wildcard listen address is no name a client could connect to
+		if ip := net.ParseIP(h); h == "" || (ip != nil && ip.IsUnspecified()) {
+			continue
+		}
+		names = append(names, h)
+	}
+	hosts = names
+
+	if dir == "" {
+		// the CA clients were told to trust has to survive restarts and upgrades, a temporary one wouldn't
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, "", fmt.Errorf("no directory for the development CA, set one with %q: %v",
+				FlagName(prefix, "tls-dev-certificate-dir"), err)
+		}
+		dir = filepath.Join(cache, "go-srv", listenerName(prefix, SchemeHTTPS))
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, "", err
+	}
+	caPath := filepath.Join(dir, devCAFile)
+	certPath, keyPath := filepath.Join(dir, devCertFile), filepath.Join(dir, devCertKey)
+
+	ca, caKey, err := loadDevCA(dir)
+	if err == nil {
+		if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil && devLeafCovers(&cert, ca, hosts) {
+			return &cert, caPath, nil
+		}
+	} else {
+		if ca, caKey, err = newDevCA(); err != nil {
+			return nil, "", err
+		}
+		keyDer, err := x509.MarshalECPrivateKey(caKey)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := writeDevFiles(map[string][]byte{
+			caPath:                       pemBlock("CERTIFICATE", ca.Raw),
+			filepath.Join(dir, devCAKey): pemBlock("EC PRIVATE KEY", keyDer),
+		}); err != nil {
+			return nil, "", err
+		}
+	}
+
+	certPEM, keyPEM, err := newDevLeaf(ca, caKey, hosts)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := writeDevFiles(map[string][]byte{certPath: certPEM, keyPath: keyPEM}); err != nil {
+		return nil, "", err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	return &cert, caPath, err
+}
+
+func newDevCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"go-srv development"}, CommonName: "go-srv development CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(devCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+func newDevLeaf(ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"go-srv development"}, CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(devCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pemBlock("CERTIFICATE", der), pemBlock("EC PRIVATE KEY", keyDer), nil
+}
+
+func loadDevCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, devCAFile), filepath.Join(dir, devCAKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok || time.Now().After(ca.NotAfter) {
+		return nil, nil, errors.New("unusable development CA")
+	}
+	return ca, key, nil
+}
+
+// devLeafCovers tells if a persisted leaf can be reused, it has to be signed by the CA clients were told to trust,
+// valid for a while and cover all hosts
+func devLeafCovers(cert *tls.Certificate, ca *x509.Certificate, hosts []string) bool {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil || time.Now().Add(24*time.Hour).After(leaf.NotAfter) {
+		return false
+	}
+	if leaf.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	for _, h := range hosts {
+		if leaf.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func pemBlock(typ string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+func writeDevFiles(files map[string][]byte) error {
+	for path, b := range files {
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			return fmt.Errorf("failed to write development certificate: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package schema
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDevCertificate(t *testing.T) {
+	dir := t.TempDir()
+	cert, caPath, err := devCertificate(dir, "app", "dev.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caPath != filepath.Join(dir, devCAFile) {
+		t.Fatalf("wrong CA path: got %s want %s", caPath, filepath.Join(dir, devCAFile))
+	}
+
+	caPEM, err := os.ReadFile(caPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"dev.local", "localhost", "127.0.0.1"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Fatalf("certificate doesn't verify for %s: %v", host, err)
+		}
+	}
+
+	// a second start reuses the persisted certificate
+	again, _, err := devCertificate(dir, "app", "dev.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again.Certificate[0]) != string(cert.Certificate[0]) {
+		t.Fatal("persisted development certificate was not reused")
+	}
+
+	// a leaf which doesn't chain to the persisted CA is replaced
+	otherCA, otherKey, err := newDevCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM, err := newDevLeaf(otherCA, otherKey, []string{"dev.local", "localhost", "127.0.0.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeDevFiles(map[string][]byte{
+		filepath.Join(dir, devCertFile): certPEM,
+		filepath.Join(dir, devCertKey):  keyPEM,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	replaced, _, err := devCertificate(dir, "app", "dev.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err = x509.ParseCertificate(replaced.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "dev.local", Roots: roots}); err != nil {
+		t.Fatalf("leaf signed by another CA was reused: %v", err)
+	}
+}
+
+func TestDevCertificateWithoutDir(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	userCache, err := os.UserCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, first, err := devCertificate("", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(userCache, "go-srv", "app", devCAFile); first != want {
+		t.Fatalf("wrong CA path: got %s want %s", first, want)
+	}
+	ca, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a restart reuses the CA clients were told to trust
+	_, second, err := devCertificate("", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := os.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first || !bytes.Equal(again, ca) {
+		t.Fatalf("development CA replaced on restart: %s and %s", first, second)
+	}
+	fi, err := os.Stat(filepath.Dir(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0700 {
+		t.Fatalf("wrong CA directory permissions: got %o want %o", perm, 0700)
+	}
+}
+
+func TestDevCertificatesNotImplicit(t *testing.T) {
+	tf := &TLSFlg{HTTPFlg: HTTPFlg{Host: "127.0.0.1"}}
+	defer func() {
+		if tf.listener != nil {
+			_ = tf.listener.Close()
+		}
+	}()
+	if _, err := tf.Serve(ServerConfig{Logger: log.New(io.Discard, "", 0)}, nil); err == nil {
+		t.Fatal("expected an error without certificates")
+	}
+}
@@ -0,0 +1,23 @@
+package schema
+
+import (
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// enableH2C lets the plain http server speak HTTP/2 without TLS, both with prior knowledge and through an Upgrade.
+// The HTTP/2 server is registered with the http server, so Shutdown sends a GOAWAY to the HTTP/2 connections as well.
+func (h *HTTPFlg) enableH2C(httpSrv *http.Server) error {
+	h2s := &http2.Server{
+		MaxConcurrentStreams: h.HTTP2MaxConcurrentStreams,
+		MaxReadFrameSize:     h.HTTP2MaxReadFrameSize,
+		IdleTimeout:          h.HTTP2IdleTimeout,
+	}
+	if err := http2.ConfigureServer(httpSrv, h2s); err != nil {
+		return err
+	}
+	httpSrv.Handler = h2c.NewHandler(httpSrv.Handler, h2s)
+	return nil
+}
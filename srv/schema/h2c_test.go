@@ -0,0 +1,192 @@
+package schema
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	h := &HTTPFlg{Host: "127.0.0.1", H2C: true, HTTP2MaxConcurrentStreams: 10}
+	eg := new(errgroup.Group)
+	hs, err := h.Serve(ServerConfig{
+		Logger: log.New(io.Discard, "", 0),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Proto))
+		}),
+	}, eg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := client.Get("http://" + h.listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "HTTP/2.0" {
+		t.Fatalf("wrong protocol: got %s want %s", body, "HTTP/2.0")
+	}
+
+	if err := hs.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	h := &HTTPFlg{Host: "127.0.0.1", H2C: true}
+	eg := new(errgroup.Group)
+	hs, err := h.Serve(ServerConfig{
+		Logger: log.New(io.Discard, "", 0),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("hello"))
+		}),
+	}, eg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = hs.Shutdown(context.Background())
+		_ = eg.Wait()
+	}()
+
+	conn, err := net.Dial("tcp", h.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// the settings are MAX_CONCURRENT_STREAMS 100, base64url encoded
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABk\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("wrong status: got %d want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	// the response to the upgraded request comes in HTTP/2 frames on stream 1, the request itself keeps HTTP/1.1
+	// as its protocol
+	if _, err := io.WriteString(conn, http2.ClientPreface); err != nil {
+		t.Fatal(err)
+	}
+	framer := http2.NewFramer(conn, br)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	if err := framer.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	var status, body string
+	for done := false; !done; {
+		f, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				if err := framer.WriteSettingsAck(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		case *http2.MetaHeadersFrame:
+			status = f.PseudoValue("status")
+			done = f.StreamEnded()
+		case *http2.DataFrame:
+			body += string(f.Data())
+			done = f.StreamEnded()
+		}
+	}
+	if status != "200" {
+		t.Fatalf("wrong status: got %s want %s", status, "200")
+	}
+	if body != "hello" {
+		t.Fatalf("wrong body: got %s want %s", body, "hello")
+	}
+}
+
+func TestH2CGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := &HTTPFlg{Host: "127.0.0.1", H2C: true}
+	eg := new(errgroup.Group)
+	hs, err := h.Serve(ServerConfig{
+		Logger: log.New(io.Discard, "", 0),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			_, _ = w.Write([]byte("done"))
+		}),
+	}, eg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, network, addr)
+		},
+	}}
+	type result struct {
+		body string
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		resp, err := client.Get("http://" + h.listener.Addr().String() + "/")
+		if err != nil {
+			res <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		res <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	// the HTTP/2 connection gets a GOAWAY, the stream in flight still completes
+	if err := hs.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", h.listener.Addr().String()); err == nil {
+		t.Fatal("listener still accepting after shutdown")
+	}
+	close(release)
+
+	r := <-res
+	if r.err != nil {
+		t.Fatalf("in flight h2c request failed: %v", r.err)
+	}
+	if r.body != "done" {
+		t.Fatalf("wrong body: got %s want %s", r.body, "done")
+	}
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
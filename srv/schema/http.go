@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	flag "github.com/spf13/pflag"
-	"golang.org/x/net/netutil"
 	"golang.org/x/sync/errgroup"
 	"net"
 	"net/http"
@@ -14,7 +13,9 @@ import (
 )
 
 type HTTPFlg struct {
-	Prefix       string
+	Prefix string
+	// ListenerName replaces the prefix as the Name of the listener, for the listeners sharing a prefix
+	ListenerName string
 	Host         string
 	Port         int
 	ListenLimit  int
@@ -23,25 +24,47 @@ type HTTPFlg struct {
 	WriteTimeout time.Duration
 	Handler      http.Handler
 
+	// PROXY protocol (v1 and v2) decoding, for connections from trusted load balancers only
+	ProxyProtocol      bool
+	ProxyTrustedCIDRs  []string
+	ProxyHeaderTimeout time.Duration
+
+	// cleartext HTTP/2, zero values keep the golang.org/x/net/http2 defaults
+	H2C                       bool
+	HTTP2MaxConcurrentStreams uint32
+	HTTP2MaxReadFrameSize     uint32
+	HTTP2IdleTimeout          time.Duration
+
 	listenOnce sync.Once
 	listener   net.Listener
+	// bindAddr is the configured address, Host and Port get the bound one
+	bindAddr string
+	timeouts liveTimeouts
 }
 
 func (h *HTTPFlg) RegisterFlags(fs *flag.FlagSet) {
 	prefix := h.Prefix
 
-	fs.StringVar(&h.Host, prefixer(prefix, "host"), h.Host, "the IP to listen on")
-	fs.IntVar(&h.Port, prefixer(prefix, "port"), h.Port, "the port to listen on for http connections, defaults to a random value")
-	fs.IntVar(&h.ListenLimit, prefixer(prefix, "listen-limit"), 0, "limit the number of outstanding requests")
-	fs.DurationVar(&h.KeepAlive, prefixer(prefix, "keep-alive"), 3*time.Minute, "sets the TCP keep-alive timeouts on accepted connections. It prunes dead TCP connections ( e.g. closing laptop mid-download)")
-	fs.DurationVar(&h.ReadTimeout, prefixer(prefix, "read-timeout"), 30*time.Second, "maximum duration before timing out read of the request")
-	fs.DurationVar(&h.WriteTimeout, prefixer(prefix, "write-timeout"), 30*time.Second, "maximum duration before timing out write of the response")
+	fs.StringVar(&h.Host, FlagName(prefix, "host"), h.Host, "the IP to listen on")
+	fs.IntVar(&h.Port, FlagName(prefix, "port"), h.Port, "the port to listen on for http connections, defaults to a random value")
+	fs.IntVar(&h.ListenLimit, FlagName(prefix, "listen-limit"), 0, "limit the number of outstanding requests")
+	fs.DurationVar(&h.KeepAlive, FlagName(prefix, "keep-alive"), 3*time.Minute, "sets the TCP keep-alive timeouts on accepted connections. It prunes dead TCP connections ( e.g. closing laptop mid-download)")
+	fs.DurationVar(&h.ReadTimeout, FlagName(prefix, "read-timeout"), 30*time.Second, "maximum duration before timing out read of the request")
+	fs.DurationVar(&h.WriteTimeout, FlagName(prefix, "write-timeout"), 30*time.Second, "maximum duration before timing out write of the response")
+	fs.BoolVar(&h.ProxyProtocol, FlagName(prefix, "proxy-protocol"), h.ProxyProtocol, "expect a PROXY protocol header from trusted load balancers")
+	fs.StringSliceVar(&h.ProxyTrustedCIDRs, FlagName(prefix, "proxy-protocol-trusted-cidrs"), h.ProxyTrustedCIDRs, "the networks allowed to send a PROXY protocol header, this can be repeated")
+	fs.DurationVar(&h.ProxyHeaderTimeout, FlagName(prefix, "proxy-protocol-timeout"), defaultProxyHeaderTimeout, "maximum duration before timing out read of the PROXY protocol header")
+	fs.BoolVar(&h.H2C, FlagName(prefix, "h2c"), h.H2C, "serve cleartext HTTP/2 (prior knowledge and Upgrade) next to HTTP/1.1")
+	fs.Uint32Var(&h.HTTP2MaxConcurrentStreams, FlagName(prefix, "http2-max-concurrent-streams"), h.HTTP2MaxConcurrentStreams, "maximum number of concurrent streams per HTTP/2 connection, defaults to 250")
+	fs.Uint32Var(&h.HTTP2MaxReadFrameSize, FlagName(prefix, "http2-max-read-frame-size"), h.HTTP2MaxReadFrameSize, "largest HTTP/2 frame the server is willing to read, defaults to 1MB")
+	fs.DurationVar(&h.HTTP2IdleTimeout, FlagName(prefix, "http2-idle-timeout"), h.HTTP2IdleTimeout, "how long an idle HTTP/2 connection is kept open, defaults to the idle timeout of the server")
 }
 
 func (h *HTTPFlg) Listener() (net.Listener, error) {
 	var errMsg string
 	h.listenOnce.Do(func() {
-		l, err := net.Listen("tcp", net.JoinHostPort(h.Host, strconv.Itoa(h.Port)))
+		h.bindAddr = net.JoinHostPort(h.Host, strconv.Itoa(h.Port))
+		l, err := listen("tcp", h.bindAddr, h.Prefix, h.Scheme())
 		if err != nil {
 			h.listener = nil
 			errMsg = err.Error()
@@ -59,10 +82,20 @@ func (h *HTTPFlg) Listener() (net.Listener, error) {
 		h.Host = hh
 		h.Port = p
 
-		if h.ListenLimit > 0 {
-			l = netutil.LimitListener(l, h.ListenLimit)
+		if h.ProxyProtocol {
+			pl, err := newProxyListener(l, h.ProxyTrustedCIDRs, h.ProxyHeaderTimeout)
+			if err != nil {
+				_ = l.Close()
+				h.listener = nil
+				errMsg = err.Error()
+				return
+			}
+			l = pl
 		}
 
+		// the limit can change on reload, zero is unlimited
+		l = limitListener(l, h.ListenLimit)
+
 		h.listener = l
 	})
 
@@ -99,15 +132,22 @@ func (h *HTTPFlg) Serve(s ServerConfig, eg *errgroup.Group) (*http.Server, error
 		httpSrv.Handler = s.Handler
 	}
 
+	// the timeouts can change on reload
+	httpSrv.Handler = h.timeouts.wrap(httpSrv.Handler)
+
 	if s.Callbacks != nil {
 		s.Callbacks.ConfigureListener(httpSrv, h.Scheme(), listener.Addr().String())
 	}
+	listener = s.Conns.attach(httpSrv, listener)
 
-	address := listener.Addr().String()
-	p := h.Prefix
-	if p == "" {
-		p = h.Scheme()
+	if h.H2C {
+		if err := h.enableH2C(httpSrv); err != nil {
+			return nil, fmt.Errorf("failed to configure h2c: %v", err)
+		}
 	}
+
+	address := listener.Addr().String()
+	p := h.Name()
 	s.Logger.Printf("Serving at %s://%s", p, address)
 	eg.Go(func() error {
 		if herr := httpSrv.Serve(listener); herr != nil && herr != http.ErrServerClosed {
@@ -125,6 +165,14 @@ func (h *HTTPFlg) Scheme() string {
 	return SchemeHTTP
 }
 
+// Name identifies the listener in logs and when handing its socket over, defaults to the scheme
+func (h *HTTPFlg) Name() string {
+	if h.ListenerName != "" {
+		return h.ListenerName
+	}
+	return listenerName(h.Prefix, h.Scheme())
+}
+
 func (h *HTTPFlg) String() string {
 		return fmt.Sprintf("Prefix: %s,Host: %s,Port: %d,ListenLimit: %d,KeepAlive: %d,ReadTimeout: %d,WriteTimeout: %d\n",
 			h.Prefix, h.Host, h.Port, h.ListenLimit, h.KeepAlive, h.ReadTimeout, h.WriteTimeout)
@@ -2,17 +2,16 @@ package schema
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"fmt"
 	flag "github.com/spf13/pflag"
-	"golang.org/x/net/netutil"
+	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/sync/errgroup"
 	"net"
 	"net/http"
-	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,20 +20,80 @@ type TLSFlg struct {
 	Cert    string
 	CertKey string
 	CACert  string
+
+	// SNICerts are extra "certificate,key" pairs, picked by the server name the client asks for
+	SNICerts []string
+	// CertDir holds extra pairs named <name>.crt and <name>.key, or <name>.pem and <name>-key.pem
+	CertDir string
+
+	// Profile is one of TLSProfileModern, TLSProfileIntermediate (the default) or TLSProfileLegacy
+	Profile string
+	// MinVersion and MaxVersion ("1.0" to "1.3") override the versions of the profile
+	MinVersion string
+	MaxVersion string
+	// ClientAuth is one of the ClientAuth modes, it defaults to ClientAuthRequire when CACert is set
+	ClientAuth string
+	// SessionTicketKeys is a file with one key per line, the first one encrypts new tickets
+	SessionTicketKeys string
+
+	// CRLFiles are PEM or DER encoded revocation lists for the client certificates, reloaded when they change
+	CRLFiles []string
+	// OCSPResponses are pre-fetched DER encoded OCSP responses, stapled to the certificates they were issued for
+	OCSPResponses []string
+
+	// ReloadInterval is how often the certificate files are checked for changes, negative disables reloading
+	ReloadInterval time.Duration
+
+	ACME ACMEFlg
+
+	// DevCerts generates a self-signed CA and certificate for local development, it's never enabled implicitly
+	DevCerts bool
+	// DevCertDir keeps the development CA and certificate across restarts, go-srv/<name> in the user cache
+	// directory by default
+	DevCertDir string
+
+	// certs is kept to reload the certificate files on demand
+	certs    *certReloader
+	reloaded atomic.Pointer[pendingCerts]
+
+	acmeOnce sync.Once
+	acme     *autocert.Manager
+	acmeErr  error
 }
 
 func (t *TLSFlg) RegisterFlags(fs *flag.FlagSet) {
 	prefix := t.Prefix
 
-	fs.StringVar(&t.Host, prefixer(prefix, "tls-host"), t.Host, "the IP to listen on")
-	fs.IntVar(&t.Port, prefixer(prefix, "tls-port"), t.Port, "the port to listen on for secure connections, defaults to a random value")
-	fs.StringVar(&t.Cert, prefixer(prefix, "tls-certificate"), t.Cert, "the certificate to use for secure connections")
-	fs.StringVar(&t.CertKey, prefixer(prefix, "tls-key"), t.CertKey, "the private key to use for secure connections")
-	fs.StringVar(&t.CACert, prefixer(prefix, "tls-ca"), t.CACert, "the certificate authority file to be used with mutual TLS auth")
-	fs.IntVar(&t.ListenLimit, prefixer(prefix, "tls-listen-limit"), 0, "limit the number of outstanding requests")
-	fs.DurationVar(&t.KeepAlive, prefixer(prefix, "tls-keep-alive"), 3*time.Minute, "sets the TCP keep-alive timeouts on accepted connections. It prunes dead TCP connections (e.g., closing laptop mid-download)")
-	fs.DurationVar(&t.ReadTimeout, prefixer(prefix, "tls-read-timeout"), 30*time.Second, "maximum duration before timing out read of the request")
-	fs.DurationVar(&t.WriteTimeout, prefixer(prefix, "tls-write-timeout"), 30*time.Second, "maximum duration before timing out write of the response")
+	fs.StringVar(&t.Host, FlagName(prefix, "tls-host"), t.Host, "the IP to listen on")
+	fs.IntVar(&t.Port, FlagName(prefix, "tls-port"), t.Port, "the port to listen on for secure connections, defaults to a random value")
+	fs.StringVar(&t.Cert, FlagName(prefix, "tls-certificate"), t.Cert, "the certificate to use for secure connections")
+	fs.StringVar(&t.CertKey, FlagName(prefix, "tls-key"), t.CertKey, "the private key to use for secure connections")
+	fs.StringVar(&t.CACert, FlagName(prefix, "tls-ca"), t.CACert, "the certificate authority file to be used with mutual TLS auth")
+	fs.StringArrayVar(&t.SNICerts, FlagName(prefix, "tls-sni-certificate"), t.SNICerts, "an extra certificate and key, separated by a comma, selected by the server name (SNI) of the client, this can be repeated")
+	fs.StringVar(&t.CertDir, FlagName(prefix, "tls-certificate-dir"), t.CertDir, "a directory of extra certificates (<name>.crt and <name>.key) selected by the server name (SNI) of the client")
+	fs.BoolVar(&t.ACME.Enabled, FlagName(prefix, "tls-acme"), t.ACME.Enabled, "obtain and renew certificates through ACME (e.g. Let's Encrypt)")
+	fs.StringVar(&t.ACME.DirectoryURL, FlagName(prefix, "tls-acme-directory-url"), t.ACME.DirectoryURL, "the ACME directory to use, defaults to Let's Encrypt")
+	fs.StringVar(&t.ACME.DirectoryCA, FlagName(prefix, "tls-acme-directory-ca"), t.ACME.DirectoryCA, "the certificate authority file to trust for the ACME directory, e.g. for a local Pebble instance")
+	fs.StringVar(&t.ACME.CacheDir, FlagName(prefix, "tls-acme-cache-dir"), t.ACME.CacheDir, "the directory to keep the ACME account and certificates in")
+	fs.StringSliceVar(&t.ACME.Hosts, FlagName(prefix, "tls-acme-host"), t.ACME.Hosts, "a host name to obtain certificates for, this can be repeated")
+	fs.StringVar(&t.ACME.Email, FlagName(prefix, "tls-acme-email"), t.ACME.Email, "the contact email of the ACME account")
+	fs.BoolVar(&t.DevCerts, FlagName(prefix, "tls-dev-certificates"), t.DevCerts, "generate a self-signed certificate for local development, never use this in production")
+	fs.StringVar(&t.DevCertDir, FlagName(prefix, "tls-dev-certificate-dir"), t.DevCertDir, "the directory to keep the generated development CA and certificate in, defaults to the user cache directory")
+	fs.StringVar(&t.Profile, FlagName(prefix, "tls-profile"), t.Profile, "the TLS security profile: modern, intermediate or legacy (defaults to intermediate)")
+	fs.StringVar(&t.MinVersion, FlagName(prefix, "tls-min-version"), t.MinVersion, "the minimum TLS version (1.0, 1.1, 1.2 or 1.3), defaults to the one of the profile")
+	fs.StringVar(&t.MaxVersion, FlagName(prefix, "tls-max-version"), t.MaxVersion, "the maximum TLS version (1.0, 1.1, 1.2 or 1.3)")
+	fs.StringVar(&t.ClientAuth, FlagName(prefix, "tls-client-auth"), t.ClientAuth, "the client certificate mode: none, request, verify-if-given or require (defaults to require with a CA)")
+	fs.StringVar(&t.SessionTicketKeys, FlagName(prefix, "tls-session-ticket-keys"), t.SessionTicketKeys, "a file with 32 byte session ticket keys (hex or base64, one per line), reloaded when it changes")
+	fs.StringArrayVar(&t.CRLFiles, FlagName(prefix, "tls-crl"), t.CRLFiles, "a certificate revocation list for the client certificates, this can be repeated")
+	fs.StringArrayVar(&t.OCSPResponses, FlagName(prefix, "tls-ocsp-response"), t.OCSPResponses, "a pre-fetched OCSP response (DER) to staple to the matching certificate, this can be repeated")
+	fs.DurationVar(&t.ReloadInterval, FlagName(prefix, "tls-reload-interval"), defaultCertReloadInterval, "how often to check the certificate, key and CA files for changes, a negative value disables reloading")
+	fs.IntVar(&t.ListenLimit, FlagName(prefix, "tls-listen-limit"), 0, "limit the number of outstanding requests")
+	fs.DurationVar(&t.KeepAlive, FlagName(prefix, "tls-keep-alive"), 3*time.Minute, "sets the TCP keep-alive timeouts on accepted connections. It prunes dead TCP connections (e.g., closing laptop mid-download)")
+	fs.DurationVar(&t.ReadTimeout, FlagName(prefix, "tls-read-timeout"), 30*time.Second, "maximum duration before timing out read of the request")
+	fs.DurationVar(&t.WriteTimeout, FlagName(prefix, "tls-write-timeout"), 30*time.Second, "maximum duration before timing out write of the response")
+	fs.BoolVar(&t.ProxyProtocol, FlagName(prefix, "tls-proxy-protocol"), t.ProxyProtocol, "expect a PROXY protocol header from trusted load balancers")
+	fs.StringSliceVar(&t.ProxyTrustedCIDRs, FlagName(prefix, "tls-proxy-protocol-trusted-cidrs"), t.ProxyTrustedCIDRs, "the networks allowed to send a PROXY protocol header, this can be repeated")
+	fs.DurationVar(&t.ProxyHeaderTimeout, FlagName(prefix, "tls-proxy-protocol-timeout"), defaultProxyHeaderTimeout, "maximum duration before timing out read of the PROXY protocol header")
 }
 
 func (t *TLSFlg) ApplyDefaults(values *HTTPFlg) {
@@ -66,8 +125,8 @@ func (t *TLSFlg) ApplyDefaults(values *HTTPFlg) {
 func (t *TLSFlg) Listener() (net.Listener, error) {
 	var errMsg string
 	t.listenOnce.Do(func() {
-		addr := net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
-		l, err := net.Listen("tcp", addr)
+		t.bindAddr = net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+		l, err := listen("tcp", t.bindAddr, t.Prefix, t.Scheme())
 		if err != nil {
 			t.listener = nil
 			errMsg = err.Error()
@@ -85,10 +144,20 @@ func (t *TLSFlg) Listener() (net.Listener, error) {
 		t.Host = hh
 		t.Port = p
 
-		if t.ListenLimit > 0 {
-			l = netutil.LimitListener(l, t.ListenLimit)
+		if t.ProxyProtocol {
+			pl, err := newProxyListener(l, t.ProxyTrustedCIDRs, t.ProxyHeaderTimeout)
+			if err != nil {
+				_ = l.Close()
+				t.listener = nil
+				errMsg = err.Error()
+				return
+			}
+			l = pl
 		}
 
+		// the limit can change on reload, zero is unlimited
+		l = limitListener(l, t.ListenLimit)
+
 		t.listener = l
 	})
 
@@ -122,42 +191,80 @@ func (t *TLSFlg) Serve(s ServerConfig, eg *errgroup.Group) (*http.Server, error)
 	if t.Handler != nil { // local values take precedence over the default
 		httpsServer.Handler = t.Handler
 	}
+	// the timeouts can change on reload
+	httpsServer.Handler = t.timeouts.wrap(httpsServer.Handler)
 
+	settings, err := t.tlsSettings()
+	if err != nil {
+		return nil, err
+	}
 	httpsServer.TLSConfig = &tls.Config{
 		PreferServerCipherSuites: true,
-		CurvePreferences:         []tls.CurveID{tls.CurveP256, tls.X25519, tls.CurveP384},
 		NextProtos:               []string{"h2", "http/1.1"},
-		MinVersion:               tls.VersionTLS12,
-		CipherSuites: []uint16{
-			tls.TLS_AES_128_GCM_SHA256,
-			tls.TLS_AES_256_GCM_SHA384,
-			tls.TLS_CHACHA20_POLY1305_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-		},
 	}
+	settings.apply(httpsServer.TLSConfig)
 
+	var pairs []keyPair
 	if t.Cert != "" && t.CertKey != "" {
-		httpsServer.TLSConfig.Certificates = make([]tls.Certificate, 1)
-		httpsServer.TLSConfig.Certificates[0], err = tls.LoadX509KeyPair(t.Cert, t.CertKey)
+		pairs = append(pairs, keyPair{cert: t.Cert, key: t.CertKey})
+	}
+	for _, v := range t.SNICerts {
+		pair, perr := parseKeyPair(v)
+		if perr != nil {
+			return nil, fmt.Errorf("invalid %s: %v", FlagName(prefix, "tls-sni-certificate"), perr)
+		}
+		pairs = append(pairs, pair)
+	}
+
+	files := certFiles{
+		pairs:          pairs,
+		certDir:        t.CertDir,
+		caFile:         t.CACert,
+		ticketKeysFile: t.SessionTicketKeys,
+		crlFiles:       t.CRLFiles,
+		ocspFiles:      t.OCSPResponses,
+	}
+	if len(t.CRLFiles) > 0 && t.CACert == "" {
+		return nil, fmt.Errorf("%q requires %q", FlagName(prefix, "tls-crl"), FlagName(prefix, "tls-ca"))
+	}
+	if len(t.OCSPResponses) > 0 && len(pairs) == 0 && t.CertDir == "" {
+		return nil, fmt.Errorf("%q requires certificates to staple to", FlagName(prefix, "tls-ocsp-response"))
+	}
+	var certs *certReloader
+	if len(pairs) > 0 || t.CertDir != "" || t.CACert != "" || t.SessionTicketKeys != "" {
+		certs, err = newCertReloader(files, t.ReloadInterval, s.Logger)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load TLS certificate and key: %v", err)
+			return nil, err
+		}
+		if len(pairs) > 0 || t.CertDir != "" {
+			httpsServer.TLSConfig.GetCertificate = certs.GetCertificate
+		}
+		if t.CACert != "" {
+			httpsServer.TLSConfig.ClientCAs = certs.state().clientCAs
+			httpsServer.TLSConfig.GetConfigForClient = certs.configForClient(httpsServer.TLSConfig)
+			httpsServer.TLSConfig.VerifyPeerCertificate = certs.verifyPeerCertificate
+		}
+		certs.tlsConfig = httpsServer.TLSConfig
+		certs.applyTicketKeys()
+	}
+	t.certs = certs
+
+	if t.DevCerts {
+		if len(pairs) > 0 || t.CertDir != "" || t.ACME.Enabled {
+			return nil, fmt.Errorf("%q can't be combined with other certificates", FlagName(prefix, "tls-dev-certificates"))
+		}
+		cert, caPath, derr := devCertificate(t.DevCertDir, t.Prefix, t.Host)
+		if derr != nil {
+			return nil, fmt.Errorf("failed to create development certificate: %v", derr)
 		}
+		httpsServer.TLSConfig.Certificates = []tls.Certificate{*cert}
+		s.Logger.Printf("Using a self-signed development certificate for %s, trust its CA with: curl --cacert %s", t.Name(), caPath)
 	}
 
-	if t.CACert != "" {
-		caCert, caCertErr := os.ReadFile(t.CACert)
-		if caCertErr != nil {
-			return nil, fmt.Errorf("failed to read CA certificate: %v", caCertErr)
+	if t.ACME.Enabled {
+		if err := t.enableACME(httpsServer.TLSConfig, certs); err != nil {
+			return nil, err
 		}
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-		httpsServer.TLSConfig.ClientCAs = caCertPool
-		httpsServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
 	}
 
 	if s.Callbacks != nil {
@@ -166,24 +273,26 @@ func (t *TLSFlg) Serve(s ServerConfig, eg *errgroup.Group) (*http.Server, error)
 
 	if len(httpsServer.TLSConfig.Certificates) == 0 && httpsServer.TLSConfig.GetCertificate == nil {
 		if t.Cert == "" {
-			return nil, fmt.Errorf("the required flag %q was not specified", prefixer(prefix, "tls-certificate"))
+			return nil, fmt.Errorf("the required flag %q was not specified (or %q for local development)", FlagName(prefix, "tls-certificate"), FlagName(prefix, "tls-dev-certificates"))
 		}
 		if t.CertKey == "" {
-			return nil, fmt.Errorf("the required flag %q was not specified", prefixer(prefix, "tls-key"))
+			return nil, fmt.Errorf("the required flag %q was not specified", FlagName(prefix, "tls-key"))
 		}
 	}
 
 	if s.Callbacks != nil {
 		s.Callbacks.ConfigureListener(httpsServer, t.Scheme(), listener.Addr().String())
 	}
+	listener = s.Conns.attach(httpsServer, listener)
 
 	address := listener.Addr().String()
-	p := t.Prefix
-	if p == "" {
-		p = t.Scheme()
-	}
+	p := t.Name()
 	s.Logger.Printf("Serving at %s://%s", p, address)
 	tlsListener := tls.NewListener(listener, httpsServer.TLSConfig)
+	if certs != nil {
+		go certs.watch()
+		httpsServer.RegisterOnShutdown(certs.stop)
+	}
 	eg.Go(func() error {
 		if terr := httpsServer.Serve(tlsListener); terr != nil && terr != http.ErrServerClosed {
 			s.Logger.Printf("error stopping %s listener: %v", p, terr)
@@ -201,7 +310,20 @@ func (t *TLSFlg) Scheme() string {
 	return SchemeHTTPS
 }
 
+// Name identifies the listener in logs and when handing its socket over, defaults to the scheme
+func (t *TLSFlg) Name() string {
+	if t.ListenerName != "" {
+		return t.ListenerName
+	}
+	return listenerName(t.Prefix, t.Scheme())
+}
+
 func (h *TLSFlg) String() string {
-	return fmt.Sprintf("Prefix: %s,Host: %s,Port: %d,ListenLimit: %d,KeepAlive: %d,ReadTimeout: %d,WriteTimeout: %d\n",
-		h.Prefix, h.Host, h.Port, h.ListenLimit, h.KeepAlive, h.ReadTimeout, h.WriteTimeout)
+	settings, err := h.tlsSettings()
+	tlsInfo := settings.String()
+	if err != nil {
+		tlsInfo = "Invalid: " + err.Error()
+	}
+	return fmt.Sprintf("Prefix: %s,Host: %s,Port: %d,ListenLimit: %d,KeepAlive: %d,ReadTimeout: %d,WriteTimeout: %d,%s\n",
+		h.Prefix, h.Host, h.Port, h.ListenLimit, h.KeepAlive, h.ReadTimeout, h.WriteTimeout, tlsInfo)
 }
@@ -30,4 +30,6 @@ type ServerConfig struct {
 	Handler        http.Handler
 	Callbacks      Hook
 	CleanupTimeout time.Duration
+	// Conns tracks the connections and streams of the server, optional
+	Conns *ConnRegistry
 }
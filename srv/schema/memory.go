@@ -0,0 +1,155 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// MemoryFlg serves plain http over in-memory connections, so tests can run a full server without sockets.
+// It answers to the http scheme, connect to it with Client or DialContext. It can't be handed off on restart.
+type MemoryFlg struct {
+	Prefix       string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	Handler      http.Handler
+
+	listenOnce sync.Once
+	listener   *memoryListener
+}
+
+// Listener returns the in-memory listener, it is created on first use and can't fail
+func (m *MemoryFlg) Listener() (net.Listener, error) {
+	return m.memoryListener(), nil
+}
+
+func (m *MemoryFlg) memoryListener() *memoryListener {
+	m.listenOnce.Do(func() {
+		m.listener = &memoryListener{
+			addr:  memoryAddr(m.Name()),
+			conns: make(chan net.Conn),
+			done:  make(chan struct{}),
+		}
+	})
+	return m.listener
+}
+
+// DialContext opens a connection to the listener, whatever the address, it can be used as http.Transport.DialContext
+func (m *MemoryFlg) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	return m.memoryListener().dial(ctx)
+}
+
+// Client returns an http client connected to the listener, the host part of request URLs is ignored
+func (m *MemoryFlg) Client() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{DialContext: m.DialContext},
+	}
+}
+
+func (m *MemoryFlg) Serve(s ServerConfig, eg *errgroup.Group) (*http.Server, error) {
+	listener, err := m.Listener()
+	if err != nil {
+		return nil, err
+	}
+
+	memSrv := &http.Server{
+		MaxHeaderBytes: s.MaxHeaderSize,
+		ReadTimeout:    m.ReadTimeout,
+		WriteTimeout:   m.WriteTimeout,
+		Handler:        s.Handler,
+	}
+
+	if int64(s.CleanupTimeout) > 0 {
+		memSrv.IdleTimeout = s.CleanupTimeout
+	}
+
+	if m.Handler != nil { // local values take precedence over the default
+		memSrv.Handler = m.Handler
+	}
+
+	address := listener.Addr().String()
+	if s.Callbacks != nil {
+		s.Callbacks.ConfigureListener(memSrv, m.Scheme(), address)
+	}
+	listener = s.Conns.attach(memSrv, listener)
+
+	s.Logger.Printf("Serving at %s://%s", memoryNetwork, address)
+	eg.Go(func() error {
+		if merr := memSrv.Serve(listener); merr != nil && merr != http.ErrServerClosed {
+			s.Logger.Printf("Error stopping %s listener: %v", address, merr)
+			return merr
+		}
+		s.Logger.Printf("Stopped serving at %s://%s", memoryNetwork, address)
+		return nil
+	})
+
+	return memSrv, nil
+}
+
+func (m *MemoryFlg) Scheme() string {
+	return SchemeHTTP
+}
+
+// Name identifies the listener in logs, defaults to memory
+func (m *MemoryFlg) Name() string {
+	return listenerName(m.Prefix, memoryNetwork)
+}
+
+func (m *MemoryFlg) String() string {
+	return fmt.Sprintf("Prefix: %s,ReadTimeout: %d,WriteTimeout: %d\n", m.Prefix, m.ReadTimeout, m.WriteTimeout)
+}
+
+const memoryNetwork = "memory"
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return memoryNetwork }
+func (a memoryAddr) String() string  { return string(a) }
+
+// memoryListener hands out the server side of net.Pipe connections, like grpc's bufconn
+type memoryListener struct {
+	addr      memoryAddr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *memoryListener) dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	var err error
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		err = errors.New("in-memory listener is closed")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	_ = client.Close()
+	_ = server.Close()
+	return nil, err
+}
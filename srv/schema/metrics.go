@@ -0,0 +1,39 @@
+package schema
+
+import (
+	"context"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	// KeyOutcome tags a measurement with its result (success or failure)
+	KeyOutcome = tag.MustNewKey("outcome")
+
+	// MeasureCertReloads counts the attempts to reload TLS material from disk
+	MeasureCertReloads = stats.Int64("go-srv/tls/certificate_reloads", "Number of TLS certificate reloads", stats.UnitDimensionless)
+
+	// CertReloadCountView is the number of TLS certificate reloads by outcome
+	CertReloadCountView = &view.View{
+		Name:        "go-srv/tls/certificate_reloads",
+		Description: "Count of TLS certificate reloads by outcome",
+		Measure:     MeasureCertReloads,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeyOutcome},
+	}
+)
+
+// DefaultViews are the views for all the measures recorded by the listeners
+var DefaultViews = []*view.View{
+	CertReloadCountView,
+}
+
+func recordOutcome(m *stats.Int64Measure, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	_ = stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(KeyOutcome, outcome)}, m.M(1))
+}
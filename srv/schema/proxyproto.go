@@ -0,0 +1,241 @@
+package schema
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PROXY protocol, see https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLength = 107
+
+	proxyV2HeaderLength = 16
+	proxyV2CmdLocal     = 0x0
+	proxyV2CmdProxy     = 0x1
+	proxyV2FamilyTCP4   = 0x1
+	proxyV2FamilyTCP6   = 0x2
+
+	defaultProxyHeaderTimeout = 5 * time.Second
+)
+
+// proxyListener decodes a PROXY protocol header on connections from trusted load balancers,
+// so the accepted connections report the original client address
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+func newProxyListener(l net.Listener, cidrs []string, timeout time.Duration) (net.Listener, error) {
+	if len(cidrs) == 0 {
+		return nil, errors.New("the PROXY protocol requires at least one trusted CIDR")
+	}
+	pl := &proxyListener{
+		Listener: l,
+		timeout:  timeout,
+	}
+	if pl.timeout <= 0 {
+		pl.timeout = defaultProxyHeaderTimeout
+	}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted CIDR for the PROXY protocol: %v", err)
+		}
+		pl.trusted = append(pl.trusted, ipNet)
+	}
+	return pl, nil
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	return &proxyConn{
+		Conn:    c,
+		br:      bufio.NewReader(c),
+		timeout: l.timeout,
+	}, nil
+}
+
+func (l *proxyListener) File() (*os.File, error) {
+	return ListenerFile(l.Listener)
+}
+
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range l.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn reads the header lazily, on the connection's own goroutine, so a slow peer can't block Accept
+type proxyConn struct {
+	net.Conn
+	br      *bufio.Reader
+	timeout time.Duration
+
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer func() { _ = c.Conn.SetReadDeadline(time.Time{}) }()
+
+	first, err := c.br.Peek(1)
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	switch {
+	case first[0] == proxyV1Prefix[0] && c.hasPrefix([]byte(proxyV1Prefix)):
+		c.remoteAddr, c.localAddr, c.err = readProxyV1(c.br)
+	case first[0] == proxyV2Signature[0] && c.hasPrefix(proxyV2Signature):
+		c.remoteAddr, c.localAddr, c.err = readProxyV2(c.br)
+	default:
+		// no header, the connection is used as is
+	}
+
+	if c.err != nil {
+		c.err = fmt.Errorf("invalid PROXY protocol header from %s: %v", c.Conn.RemoteAddr(), c.err)
+		_ = c.Conn.Close()
+	}
+}
+
+// hasPrefix tells a header apart from a request that starts with the same byte, e.g. POST
+func (c *proxyConn) hasPrefix(prefix []byte) bool {
+	b, _ := c.br.Peek(len(prefix))
+	return bytes.Equal(b, prefix)
+}
+
+func readProxyV1(br *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasPrefix(line, []byte(proxyV1Prefix)) || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("malformed v1 header")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errors.New("malformed v1 header")
+	}
+
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, serr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, derr := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || serr != nil || derr != nil {
+		return nil, nil, errors.New("malformed v1 address")
+	}
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
+
+func readProxyV2(br *bufio.Reader) (src, dst net.Addr, err error) {
+	hdr := make([]byte, proxyV2HeaderLength)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Signature) {
+		return nil, nil, errors.New("malformed v2 signature")
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported version %d", hdr[12]>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, nil, err
+	}
+
+	switch hdr[12] & 0x0f {
+	case proxyV2CmdLocal:
+		// health checks from the load balancer itself
+		return nil, nil, nil
+	case proxyV2CmdProxy:
+	default:
+		return nil, nil, fmt.Errorf("unsupported command %d", hdr[12]&0x0f)
+	}
+
+	var ipLen int
+	switch hdr[13] >> 4 {
+	case proxyV2FamilyTCP4:
+		ipLen = net.IPv4len
+	case proxyV2FamilyTCP6:
+		ipLen = net.IPv6len
+	default:
+		// unix sockets and unspecified families keep the real peer address
+		return nil, nil, nil
+	}
+
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, errors.New("truncated v2 address")
+	}
+	srcIP := net.IP(payload[:ipLen])
+	dstIP := net.IP(payload[ipLen : 2*ipLen])
+	srcPort := binary.BigEndian.Uint16(payload[2*ipLen:])
+	dstPort := binary.BigEndian.Uint16(payload[2*ipLen+2:])
+	// any TLVs following the addresses are ignored
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
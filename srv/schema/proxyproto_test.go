@@ -0,0 +1,230 @@
+package schema
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func proxyV2Header(src, dst *net.TCPAddr) []byte {
+	var b bytes.Buffer
+	b.Write(proxyV2Signature)
+	b.WriteByte(0x20 | proxyV2CmdProxy)
+	b.WriteByte(proxyV2FamilyTCP4<<4 | 0x1)
+	_ = binary.Write(&b, binary.BigEndian, uint16(12))
+	b.Write(src.IP.To4())
+	b.Write(dst.IP.To4())
+	_ = binary.Write(&b, binary.BigEndian, uint16(src.Port))
+	_ = binary.Write(&b, binary.BigEndian, uint16(dst.Port))
+	return b.Bytes()
+}
+
+func TestProxyProtocol(t *testing.T) {
+	h := &HTTPFlg{
+		Host:              "127.0.0.1",
+		ProxyProtocol:     true,
+		ProxyTrustedCIDRs: []string{"127.0.0.0/8"},
+	}
+	eg := new(errgroup.Group)
+	hs, err := h.Serve(ServerConfig{
+		Logger: log.New(io.Discard, "", 0),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.RemoteAddr))
+		}),
+	}, eg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = hs.Shutdown(context.Background())
+		_ = eg.Wait()
+	}()
+
+	addr := h.listener.Addr().String()
+	tests := []struct {
+		name     string
+		header   []byte
+		expected string
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.0.2.10 10.0.0.1 56324 443\r\n"), "192.0.2.10:56324"},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 4711 443\r\n"), "[2001:db8::1]:4711"},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "127.0.0.1"},
+		{"v2 tcp4", proxyV2Header(&net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 1234}, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}), "198.51.100.7:1234"},
+		{"no header", nil, "127.0.0.1"},
+		{"no header with post", []byte("POST /ignored HTTP/1.1\r\nHost: test\r\nContent-Length: 0\r\n\r\n"), "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = c.Write(tt.header)
+		_, _ = c.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = c.Close()
+
+		got := string(body)
+		if tt.expected == "127.0.0.1" {
+			got, _, _ = net.SplitHostPort(got)
+		}
+		if got != tt.expected {
+			t.Fatalf("%s: wrong remote address: got %s want %s", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestProxyProtocolRequiresTrustedCIDRs(t *testing.T) {
+	h := &HTTPFlg{Host: "127.0.0.1", ProxyProtocol: true}
+	if _, err := h.Listener(); err == nil {
+		t.Fatal("expected an error without trusted CIDRs")
+	}
+}
+
+// acceptProxied sends data on a new connection to a PROXY protocol listener, and returns both ends
+func acceptProxied(t *testing.T, trusted []string, timeout time.Duration, data []byte) (server, client net.Conn) {
+	t.Helper()
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = raw.Close() })
+	l, err := newProxyListener(raw, trusted, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err = net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+	return server, client
+}
+
+func TestProxyProtocolUntrustedPeer(t *testing.T) {
+	header := "PROXY TCP4 192.0.2.10 10.0.0.1 56324 443\r\n"
+	c, client := acceptProxied(t, []string{"192.0.2.0/24"}, 0, []byte(header))
+	_ = client.(*net.TCPConn).CloseWrite()
+
+	if host, _, _ := net.SplitHostPort(c.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Fatalf("header of an untrusted peer was used: got %s", c.RemoteAddr())
+	}
+	// the header is not consumed, it reaches the http server as a bad request
+	b, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != header {
+		t.Fatalf("wrong data: got %q want %q", b, header)
+	}
+}
+
+func TestProxyProtocolV2Local(t *testing.T) {
+	var header bytes.Buffer
+	header.Write(proxyV2Signature)
+	header.WriteByte(0x20 | proxyV2CmdLocal)
+	header.WriteByte(0x00)
+	_ = binary.Write(&header, binary.BigEndian, uint16(0))
+	header.WriteString("hello")
+	c, client := acceptProxied(t, []string{"127.0.0.0/8"}, 0, header.Bytes())
+	_ = client.(*net.TCPConn).CloseWrite()
+
+	b, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("wrong data after a LOCAL header: got %q want %q", b, "hello")
+	}
+	if host, _, _ := net.SplitHostPort(c.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Fatalf("a LOCAL header should keep the peer address: got %s", c.RemoteAddr())
+	}
+}
+
+func TestProxyProtocolInvalidHeader(t *testing.T) {
+	v2 := func(verCmd byte, length uint16, payload []byte) []byte {
+		var b bytes.Buffer
+		b.Write(proxyV2Signature)
+		b.WriteByte(verCmd)
+		b.WriteByte(proxyV2FamilyTCP4<<4 | 0x1)
+		_ = binary.Write(&b, binary.BigEndian, length)
+		b.Write(payload)
+		return b.Bytes()
+	}
+
+	tests := []struct {
+		name   string
+		header []byte
+	}{
+		{"v1 without crlf", []byte("PROXY TCP4 192.0.2.10 10.0.0.1 56324 443\n")},
+		{"v1 bad address", []byte("PROXY TCP4 not-an-ip 10.0.0.1 56324 443\r\n")},
+		{"v1 bad port", []byte("PROXY TCP4 192.0.2.10 10.0.0.1 70000 443\r\n")},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", proxyV1MaxLength) + "\r\n")},
+		{"v1 truncated", []byte("PROXY TCP4 192.0.2.10")},
+		{"v2 truncated header", proxyV2Signature[:proxyV2HeaderLength-4]},
+		{"v2 truncated payload", v2(0x20|proxyV2CmdProxy, 12, []byte{192, 0, 2, 10})},
+		{"v2 truncated address", v2(0x20|proxyV2CmdProxy, 4, []byte{192, 0, 2, 10})},
+		{"v2 bad version", v2(0x10|proxyV2CmdProxy, 0, nil)},
+		{"v2 bad command", v2(0x2f, 0, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := acceptProxied(t, []string{"127.0.0.0/8"}, 0, tt.header)
+			// the peer sends nothing more, a truncated header ends there
+			_ = client.(*net.TCPConn).CloseWrite()
+			_, err := c.Read(make([]byte, 1))
+			if err == nil || !strings.Contains(err.Error(), "invalid PROXY protocol header") {
+				t.Fatalf("wrong error: got %v", err)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolHeaderTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		sent []byte
+	}{
+		{"nothing sent", nil},
+		{"partial header", []byte("PROXY TCP4 192.0.2.10")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a trusted peer which stalls before the end of its header
+			c, _ := acceptProxied(t, []string{"127.0.0.0/8"}, 50*time.Millisecond, tt.sent)
+
+			start := time.Now()
+			_, err := c.Read(make([]byte, 1))
+			if err == nil || !strings.Contains(err.Error(), "i/o timeout") {
+				t.Fatalf("wrong error: got %v want a timeout", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("header timeout not applied: waited %s", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package schema
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Change is a setting of a listener which differs in the reloaded configuration
+type Change struct {
+	Listener string
+	Setting  string
+	From     string
+	To       string
+	// Restart is set when the listener has to be bound again for the change to take effect
+	Restart bool
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s %s: %s -> %s", c.Listener, c.Setting, c.From, c.To)
+	if c.Restart {
+		s += " (requires restart)"
+	}
+	return s
+}
+
+// Reloadable is implemented by the listeners which can take a new configuration while serving
+type Reloadable interface {
+	// Diff validates the next configuration and lists what differs from the current one. It does the work
+	// which can fail, like reading the certificate files, so Apply can't fail halfway through a reload.
+	Diff(next ServerListener) ([]Change, error)
+	// Apply takes over the settings which can change while serving, the others are kept until a restart
+	Apply(next ServerListener) error
+}
+
+// diffSetting appends a change when the values print differently
+func diffSetting(changes []Change, listener, setting string, from, to interface{}, restart bool) []Change {
+	f, t := fmt.Sprint(from), fmt.Sprint(to)
+	if f == t {
+		return changes
+	}
+	return append(changes, Change{Listener: listener, Setting: setting, From: f, To: t, Restart: restart})
+}
+
+// liveTimeouts override the read and write timeouts of the server per request, once a reload changed them.
+// The request header is still read under the timeout the listener started with. The fields of the listener
+// keep the values it started with, the reloaded ones only live here as connections read them concurrently.
+type liveTimeouts struct {
+	read    atomic.Int64
+	write   atomic.Int64
+	changed atomic.Bool
+}
+
+func (lt *liveTimeouts) set(read, write time.Duration) {
+	lt.read.Store(int64(read))
+	lt.write.Store(int64(write))
+	lt.changed.Store(true)
+}
+
+// get returns the timeouts in effect, the ones the listener started with until a reload changed them
+func (lt *liveTimeouts) get(read, write time.Duration) (time.Duration, time.Duration) {
+	if !lt.changed.Load() {
+		return read, write
+	}
+	return time.Duration(lt.read.Load()), time.Duration(lt.write.Load())
+}
+
+func (lt *liveTimeouts) wrap(next http.Handler) http.Handler {
+	if next == nil {
+		return nil
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lt.changed.Load() {
+			rc := http.NewResponseController(w)
+			_ = rc.SetReadDeadline(deadline(time.Duration(lt.read.Load())))
+			_ = rc.SetWriteDeadline(deadline(time.Duration(lt.write.Load())))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deadline is the zero time, no deadline, for a zero timeout
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// bindAddress is the address the listener was configured with, before a random port got picked
+func (h *HTTPFlg) bindAddress() string {
+	if h.bindAddr != "" {
+		return h.bindAddr
+	}
+	return net.JoinHostPort(h.Host, strconv.Itoa(h.Port))
+}
+
+func (h *HTTPFlg) Diff(next ServerListener) ([]Change, error) {
+	n, ok := next.(*HTTPFlg)
+	if !ok {
+		return nil, fmt.Errorf("%s can't be reloaded as a %T", h.Name(), next)
+	}
+	return h.diff(h.Name(), "", n)
+}
+
+func (h *HTTPFlg) Apply(next ServerListener) error {
+	n, ok := next.(*HTTPFlg)
+	if !ok {
+		return fmt.Errorf("%s can't be reloaded as a %T", h.Name(), next)
+	}
+	h.apply(n)
+	return nil
+}
+
+// diff compares the settings shared by the http and https listeners, flagPrefix tells them apart in errors
+func (h *HTTPFlg) diff(name, flagPrefix string, n *HTTPFlg) ([]Change, error) {
+	if n.ListenLimit < 0 {
+		return nil, fmt.Errorf("invalid %s: %d", FlagName(n.Prefix, flagPrefix+"listen-limit"), n.ListenLimit)
+	}
+	if n.ProxyProtocol {
+		if _, err := newProxyListener(nil, n.ProxyTrustedCIDRs, n.ProxyHeaderTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	limit, read, write := h.live()
+	var changes []Change
+	changes = diffSetting(changes, name, "Address", h.bindAddress(), n.bindAddress(), true)
+	changes = diffSetting(changes, name, "KeepAlive", h.KeepAlive, n.KeepAlive, true)
+	changes = diffSetting(changes, name, "ProxyProtocol", h.ProxyProtocol, n.ProxyProtocol, true)
+	changes = diffSetting(changes, name, "ProxyTrustedCIDRs", h.ProxyTrustedCIDRs, n.ProxyTrustedCIDRs, true)
+	changes = diffSetting(changes, name, "H2C", h.H2C, n.H2C, true)
+	changes = diffSetting(changes, name, "ListenLimit", limit, n.ListenLimit, false)
+	changes = diffSetting(changes, name, "ReadTimeout", read, n.ReadTimeout, false)
+	changes = diffSetting(changes, name, "WriteTimeout", write, n.WriteTimeout, false)
+	return changes, nil
+}
+
+// live returns the listen limit and the timeouts in effect
+func (h *HTTPFlg) live() (int, time.Duration, time.Duration) {
+	read, write := h.timeouts.get(h.ReadTimeout, h.WriteTimeout)
+	return liveLimit(h.listener, h.ListenLimit), read, write
+}
+
+// apply hands the reloaded timeouts and limit to the serving listener, its fields keep the values it started with
+func (h *HTTPFlg) apply(n *HTTPFlg) {
+	if _, read, write := h.live(); read != n.ReadTimeout || write != n.WriteTimeout {
+		h.timeouts.set(n.ReadTimeout, n.WriteTimeout)
+	}
+	if l, ok := h.listener.(*limitedListener); ok {
+		l.setLimit(n.ListenLimit)
+	}
+}
+
+// liveLimit is the limit of a bound listener, or else the configured one
+func liveLimit(l net.Listener, configured int) int {
+	if ll, ok := l.(*limitedListener); ok {
+		return ll.getLimit()
+	}
+	return configured
+}
+
+func (t *TLSFlg) Diff(next ServerListener) ([]Change, error) {
+	n, ok := next.(*TLSFlg)
+	if !ok {
+		return nil, fmt.Errorf("%s can't be reloaded as a %T", t.Name(), next)
+	}
+	if _, err := n.tlsSettings(); err != nil {
+		return nil, err
+	}
+	// the files keep their paths, but their content has to load before anything gets applied
+	if t.certs != nil {
+		st, err := t.certs.load()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", t.Name(), err)
+		}
+		t.reloaded.Store(&pendingCerts{next: n, state: st})
+	}
+
+	name := t.Name()
+	changes, err := t.HTTPFlg.diff(name, "tls-", &n.HTTPFlg)
+	if err != nil {
+		return nil, err
+	}
+	changes = diffSetting(changes, name, "Cert", t.Cert, n.Cert, true)
+	changes = diffSetting(changes, name, "CertKey", t.CertKey, n.CertKey, true)
+	changes = diffSetting(changes, name, "CACert", t.CACert, n.CACert, true)
+	changes = diffSetting(changes, name, "SNICerts", t.SNICerts, n.SNICerts, true)
+	changes = diffSetting(changes, name, "CertDir", t.CertDir, n.CertDir, true)
+	changes = diffSetting(changes, name, "CRLFiles", t.CRLFiles, n.CRLFiles, true)
+	changes = diffSetting(changes, name, "OCSPResponses", t.OCSPResponses, n.OCSPResponses, true)
+	changes = diffSetting(changes, name, "SessionTicketKeys", t.SessionTicketKeys, n.SessionTicketKeys, true)
+	changes = diffSetting(changes, name, "Profile", t.Profile, n.Profile, true)
+	changes = diffSetting(changes, name, "MinVersion", t.MinVersion, n.MinVersion, true)
+	changes = diffSetting(changes, name, "MaxVersion", t.MaxVersion, n.MaxVersion, true)
+	changes = diffSetting(changes, name, "ClientAuth", t.ClientAuth, n.ClientAuth, true)
+	changes = diffSetting(changes, name, "ACME", t.ACME, n.ACME, true)
+	changes = diffSetting(changes, name, "DevCerts", t.DevCerts, n.DevCerts, true)
+	return changes, nil
+}
+
+// pendingCerts are the certificates Diff loaded for the next configuration
+type pendingCerts struct {
+	next  *TLSFlg
+	state *certState
+}
+
+// Apply takes over the timeouts and the listen limit, and the certificates Diff loaded. Without a Diff of next,
+// the certificate files are read again, and nothing is applied when they fail to load.
+func (t *TLSFlg) Apply(next ServerListener) error {
+	n, ok := next.(*TLSFlg)
+	if !ok {
+		return fmt.Errorf("%s can't be reloaded as a %T", t.Name(), next)
+	}
+	if t.certs != nil {
+		if p := t.reloaded.Swap(nil); p != nil && p.next == n {
+			t.certs.install(p.state)
+		} else if err := t.certs.Reload(); err != nil {
+			return err
+		}
+	}
+	t.HTTPFlg.apply(&n.HTTPFlg)
+	return nil
+}
+
+func (u *UnixFlg) Diff(next ServerListener) ([]Change, error) {
+	n, ok := next.(*UnixFlg)
+	if !ok {
+		return nil, fmt.Errorf("%s can't be reloaded as a %T", u.Name(), next)
+	}
+	if n.ListenLimit < 0 {
+		return nil, fmt.Errorf("invalid %s: %d", FlagName(n.Prefix, "socket-listen-limit"), n.ListenLimit)
+	}
+
+	name := u.Name()
+	limit, read, write := u.live()
+	var changes []Change
+	changes = diffSetting(changes, name, "Path", u.Path, n.Path, true)
+	changes = diffSetting(changes, name, "Mode", fmt.Sprintf("%#o", uint32(u.Mode)), fmt.Sprintf("%#o", uint32(n.Mode)), true)
+	changes = diffSetting(changes, name, "ListenLimit", limit, n.ListenLimit, false)
+	changes = diffSetting(changes, name, "ReadTimeout", read, n.ReadTimeout, false)
+	changes = diffSetting(changes, name, "WriteTimeout", write, n.WriteTimeout, false)
+	return changes, nil
+}
+
+// live returns the listen limit and the timeouts in effect
+func (u *UnixFlg) live() (int, time.Duration, time.Duration) {
+	read, write := u.timeouts.get(u.ReadTimeout, u.WriteTimeout)
+	return liveLimit(u.listener, u.ListenLimit), read, write
+}
+
+func (u *UnixFlg) Apply(next ServerListener) error {
+	n, ok := next.(*UnixFlg)
+	if !ok {
+		return fmt.Errorf("%s can't be reloaded as a %T", u.Name(), next)
+	}
+	if _, read, write := u.live(); read != n.ReadTimeout || write != n.WriteTimeout {
+		u.timeouts.set(n.ReadTimeout, n.WriteTimeout)
+	}
+	if l, ok := u.listener.(*limitedListener); ok {
+		l.setLimit(n.ListenLimit)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package schema
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPFlgDiff(t *testing.T) {
+	current := &HTTPFlg{Prefix: "app", Host: "127.0.0.1", ReadTimeout: time.Second, ListenLimit: 1}
+	next := &HTTPFlg{Prefix: "app", Host: "127.0.0.1", Port: 8081, ReadTimeout: 2 * time.Second, ListenLimit: 1}
+
+	changes, err := current.Diff(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	want := "app Address: 127.0.0.1:0 -> 127.0.0.1:8081 (requires restart),app ReadTimeout: 1s -> 2s"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("wrong changes: got %s want %s", strings.Join(got, ","), want)
+	}
+
+	if _, err := current.Diff(&HTTPFlg{Prefix: "app", ProxyProtocol: true}); err == nil {
+		t.Fatal("expected the PROXY protocol without trusted CIDRs to be invalid")
+	}
+	if _, err := current.Diff(&UnixFlg{Prefix: "app"}); err == nil {
+		t.Fatal("expected a different listener type to be invalid")
+	}
+}
+
+func TestApplyListenLimit(t *testing.T) {
+	h := &HTTPFlg{Host: "127.0.0.1", ListenLimit: 1}
+	l, err := h.Listener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+
+	first := <-accepted
+	defer first.Close()
+	select {
+	case <-accepted:
+		t.Fatal("accepted a connection over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := h.Apply(&HTTPFlg{Host: "127.0.0.1", ListenLimit: 2}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-accepted:
+		_ = c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("raising the limit didn't let the next connection in")
+	}
+}
+
+func TestApplyKeepsListenerFields(t *testing.T) {
+	h := &HTTPFlg{Prefix: "app", Host: "127.0.0.1", ReadTimeout: time.Second, ListenLimit: 1}
+	l, err := h.Listener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	next := &HTTPFlg{Prefix: "app", Host: "127.0.0.1", ReadTimeout: 2 * time.Second, ListenLimit: 3}
+	if err := h.Apply(next); err != nil {
+		t.Fatal(err)
+	}
+	// connections read the fields while serving, the reloaded values live beside them
+	if h.ReadTimeout != time.Second || h.ListenLimit != 1 {
+		t.Fatalf("fields changed by the reload: got %s and %d", h.ReadTimeout, h.ListenLimit)
+	}
+	if limit, read, _ := h.live(); limit != 3 || read != 2*time.Second {
+		t.Fatalf("wrong live settings: got %d and %s want 3 and 2s", limit, read)
+	}
+	changes, err := h.Diff(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range changes {
+		if !c.Restart {
+			t.Fatalf("applied setting reported again: %s", c)
+		}
+	}
+}
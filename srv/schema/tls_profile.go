@@ -0,0 +1,203 @@
+package schema
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLS security profiles, following https://wiki.mozilla.org/Security/Server_Side_TLS
+const (
+	TLSProfileModern       = "modern"
+	TLSProfileIntermediate = "intermediate"
+	TLSProfileLegacy       = "legacy"
+)
+
+// Client certificate modes for mutual TLS
+const (
+	ClientAuthNone          = "none"
+	ClientAuthRequest       = "request"
+	ClientAuthVerifyIfGiven = "verify-if-given"
+	ClientAuthRequire       = "require"
+)
+
+type tlsProfile struct {
+	minVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+}
+
+var (
+	intermediateCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	}
+
+	// TLS 1.3 cipher suites aren't configurable, modern only allows TLS 1.3
+	tlsProfiles = map[string]tlsProfile{
+		TLSProfileModern: {
+			minVersion: tls.VersionTLS13,
+			curves:     []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		},
+		TLSProfileIntermediate: {
+			minVersion:   tls.VersionTLS12,
+			cipherSuites: intermediateCipherSuites,
+			curves:       []tls.CurveID{tls.CurveP256, tls.X25519, tls.CurveP384},
+		},
+		TLSProfileLegacy: {
+			minVersion: tls.VersionTLS10,
+			cipherSuites: append(append([]uint16{}, intermediateCipherSuites...),
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+				tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+			),
+			curves: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		},
+	}
+
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		ClientAuthNone:          tls.NoClientCert,
+		ClientAuthRequest:       tls.RequestClientCert,
+		ClientAuthVerifyIfGiven: tls.VerifyClientCertIfGiven,
+		ClientAuthRequire:       tls.RequireAndVerifyClientCert,
+	}
+)
+
+// tlsSettings are the effective profile, versions and client auth mode of a TLS listener
+type tlsSettings struct {
+	profile    string
+	minVersion uint16
+	maxVersion uint16
+	clientAuth string
+}
+
+func (t *TLSFlg) tlsSettings() (tlsSettings, error) {
+	prefix := t.Prefix
+	st := tlsSettings{
+		profile:    t.Profile,
+		clientAuth: t.ClientAuth,
+	}
+
+	if st.profile == "" {
+		st.profile = TLSProfileIntermediate
+	}
+	profile, ok := tlsProfiles[st.profile]
+	if !ok {
+		return st, fmt.Errorf("invalid %s %q, expected one of %s, %s or %s", FlagName(prefix, "tls-profile"), st.profile,
+			TLSProfileModern, TLSProfileIntermediate, TLSProfileLegacy)
+	}
+	st.minVersion = profile.minVersion
+
+	if t.MinVersion != "" {
+		if st.minVersion, ok = tlsVersions[t.MinVersion]; !ok {
+			return st, fmt.Errorf("invalid %s %q, expected 1.0, 1.1, 1.2 or 1.3", FlagName(prefix, "tls-min-version"), t.MinVersion)
+		}
+	}
+	if t.MaxVersion != "" {
+		if st.maxVersion, ok = tlsVersions[t.MaxVersion]; !ok {
+			return st, fmt.Errorf("invalid %s %q, expected 1.0, 1.1, 1.2 or 1.3", FlagName(prefix, "tls-max-version"), t.MaxVersion)
+		}
+		if st.maxVersion < st.minVersion {
+			return st, fmt.Errorf("%s is lower than the minimum version", FlagName(prefix, "tls-max-version"))
+		}
+	}
+
+	// mutual TLS is required whenever a CA is configured, unless asked otherwise
+	if st.clientAuth == "" {
+		st.clientAuth = ClientAuthNone
+		if t.CACert != "" {
+			st.clientAuth = ClientAuthRequire
+		}
+	}
+	authType, ok := clientAuthTypes[st.clientAuth]
+	if !ok {
+		return st, fmt.Errorf("invalid %s %q, expected one of %s, %s, %s or %s", FlagName(prefix, "tls-client-auth"), st.clientAuth,
+			ClientAuthNone, ClientAuthRequest, ClientAuthVerifyIfGiven, ClientAuthRequire)
+	}
+	if authType >= tls.VerifyClientCertIfGiven && t.CACert == "" {
+		return st, fmt.Errorf("%s %q requires %q", FlagName(prefix, "tls-client-auth"), st.clientAuth, FlagName(prefix, "tls-ca"))
+	}
+	return st, nil
+}
+
+func (st tlsSettings) apply(cfg *tls.Config) {
+	profile := tlsProfiles[st.profile]
+	cfg.MinVersion = st.minVersion
+	cfg.MaxVersion = st.maxVersion
+	cfg.CipherSuites = profile.cipherSuites
+	cfg.CurvePreferences = profile.curves
+	cfg.ClientAuth = clientAuthTypes[st.clientAuth]
+}
+
+func (st tlsSettings) String() string {
+	maxVersion := "any"
+	if st.maxVersion != 0 {
+		maxVersion = tlsVersionName(st.maxVersion)
+	}
+	return fmt.Sprintf("Profile: %s,MinVersion: %s,MaxVersion: %s,ClientAuth: %s",
+		st.profile, tlsVersionName(st.minVersion), maxVersion, st.clientAuth)
+}
+
+func tlsVersionName(v uint16) string {
+	for name, version := range tlsVersions {
+		if version == v {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// loadSessionTicketKeys reads one base64 or hex encoded 32 byte key per line, the first one encrypts new tickets
+func loadSessionTicketKeys(file string) ([][32]byte, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session ticket keys: %v", err)
+	}
+
+	var keys [][32]byte
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		raw, err := hex.DecodeString(line)
+		if err != nil {
+			if raw, err = base64.StdEncoding.DecodeString(line); err != nil {
+				return nil, fmt.Errorf("session ticket key is neither hex nor base64 encoded")
+			}
+		}
+		if len(raw) != 32 {
+			return nil, fmt.Errorf("session ticket key must be 32 bytes long, got %d", len(raw))
+		}
+		var key [32]byte
+		copy(key[:], raw)
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no session ticket keys found in %s", file)
+	}
+	return keys, nil
+}
@@ -0,0 +1,75 @@
+package schema
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTLSSettings(t *testing.T) {
+	tests := []struct {
+		name       string
+		flags      *TLSFlg
+		minVersion uint16
+		maxVersion uint16
+		clientAuth tls.ClientAuthType
+		invalid    bool
+	}{
+		{"defaults", &TLSFlg{}, tls.VersionTLS12, 0, tls.NoClientCert, false},
+		{"ca requires client certs", &TLSFlg{CACert: "ca.pem"}, tls.VersionTLS12, 0, tls.RequireAndVerifyClientCert, false},
+		{"optional mtls", &TLSFlg{CACert: "ca.pem", ClientAuth: ClientAuthVerifyIfGiven}, tls.VersionTLS12, 0, tls.VerifyClientCertIfGiven, false},
+		{"modern", &TLSFlg{Profile: TLSProfileModern}, tls.VersionTLS13, 0, tls.NoClientCert, false},
+		{"legacy capped", &TLSFlg{Profile: TLSProfileLegacy, MaxVersion: "1.2"}, tls.VersionTLS10, tls.VersionTLS12, tls.NoClientCert, false},
+		{"unknown profile", &TLSFlg{Profile: "paranoid"}, 0, 0, 0, true},
+		{"max below min", &TLSFlg{MinVersion: "1.3", MaxVersion: "1.2"}, 0, 0, 0, true},
+		{"verify without ca", &TLSFlg{ClientAuth: ClientAuthRequire}, 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		st, err := tt.flags.tlsSettings()
+		if tt.invalid {
+			if err == nil {
+				t.Fatalf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		cfg := &tls.Config{}
+		st.apply(cfg)
+		if cfg.MinVersion != tt.minVersion || cfg.MaxVersion != tt.maxVersion || cfg.ClientAuth != tt.clientAuth {
+			t.Fatalf("%s: wrong settings: got %s", tt.name, st)
+		}
+	}
+
+	if s := (&TLSFlg{Profile: TLSProfileModern}).String(); !strings.Contains(s, "Profile: modern,MinVersion: 1.3") {
+		t.Fatalf("effective settings missing from %q", s)
+	}
+}
+
+func TestLoadSessionTicketKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tickets")
+	content := "# current key first\n" +
+		strings.Repeat("ab", 32) + "\n" +
+		"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := loadSessionTicketKeys(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0][0] != 0xab {
+		t.Fatalf("wrong keys: got %d keys", len(keys))
+	}
+
+	if err := os.WriteFile(file, []byte("tooshort\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSessionTicketKeys(file); err == nil {
+		t.Fatal("expected an error for a short key")
+	}
+}
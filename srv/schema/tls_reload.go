@@ -0,0 +1,306 @@
+package schema
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gabibotos/go-srv/log"
+)
+
+const defaultCertReloadInterval = time.Minute
+
+// certReloader serves the certificates and the client CAs from disk, and swaps them atomically when the files change.
+// When the new files fail to load, the current ones are kept until the next change.
+type certReloader struct {
+	certFiles
+	interval time.Duration
+	logger   log.Logger
+	// tlsConfig gets the session ticket keys, it can't hand them out per handshake like the certificates
+	tlsConfig *tls.Config
+
+	current  atomic.Value // *certState
+	stamps   map[string]fileStamp
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// certFiles is the TLS material of a listener on disk
+type certFiles struct {
+	// pairs come first, the first one is the default certificate
+	pairs          []keyPair
+	certDir        string
+	caFile         string
+	ticketKeysFile string
+	crlFiles       []string
+	ocspFiles      []string
+}
+
+type certState struct {
+	certs      *certSet
+	clientCAs  *x509.CertPool
+	ticketKeys [][32]byte
+	crl        *revocationList
+	// staplesExpiry is the next update of the stapled OCSP responses, by certificate
+	staplesExpiry map[*tls.Certificate]time.Time
+}
+
+// expiry is the earliest next update of the CRLs and the OCSP responses, zero when none has one
+func (st *certState) expiry() time.Time {
+	var t time.Time
+	if st.crl != nil {
+		t = st.crl.nextUpdate
+	}
+	for _, next := range st.staplesExpiry {
+		t = earliest(t, next)
+	}
+	return t
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newCertReloader(files certFiles, interval time.Duration, logger log.Logger) (*certReloader, error) {
+	if interval == 0 {
+		interval = defaultCertReloadInterval
+	}
+	r := &certReloader{
+		certFiles: files,
+		interval:  interval,
+		logger:    logger,
+		done:      make(chan struct{}),
+	}
+
+	r.stamps = r.stat()
+	st, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.current.Store(st)
+	return r, nil
+}
+
+func (r *certReloader) load() (*certState, error) {
+	st := &certState{}
+	if len(r.pairs) > 0 || r.certDir != "" {
+		pairs := r.pairs
+		if r.certDir != "" {
+			found, err := findKeyPairs(r.certDir)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(append([]keyPair{}, pairs...), found...)
+		}
+		certs, err := loadCertSet(pairs)
+		if err != nil {
+			return nil, err
+		}
+		expiry, err := attachOCSPStaples(certs, r.ocspFiles)
+		if err != nil {
+			return nil, err
+		}
+		st.certs = certs
+		st.staplesExpiry = expiry
+	}
+	if r.caFile != "" {
+		pool, cas, err := loadCAs(r.caFile)
+		if err != nil {
+			return nil, err
+		}
+		st.clientCAs = pool
+		if len(r.crlFiles) > 0 {
+			if st.crl, err = loadCRLs(r.crlFiles, cas); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if r.ticketKeysFile != "" {
+		keys, err := loadSessionTicketKeys(r.ticketKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		st.ticketKeys = keys
+	}
+	return st, nil
+}
+
+func (r *certReloader) state() *certState {
+	return r.current.Load().(*certState)
+}
+
+// GetCertificate is used as tls.Config.GetCertificate, it picks the certificate matching the SNI server name.
+// An OCSP response past its next update isn't stapled anymore.
+func (r *certReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	st := r.state()
+	if st.certs == nil {
+		return nil, errors.New("no TLS certificates configured")
+	}
+	name := ""
+	if hello != nil {
+		name = hello.ServerName
+	}
+	cert := st.certs.forName(name)
+	if next, ok := st.staplesExpiry[cert]; ok && time.Now().After(next) {
+		unstapled := *cert
+		unstapled.OCSPStaple = nil
+		return &unstapled, nil
+	}
+	return cert, nil
+}
+
+// configForClient hands out the current client CAs, the base config is cloned for every handshake
+func (r *certReloader) configForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = r.state().clientCAs
+		return cfg, nil
+	}
+}
+
+// Reload reads the files again, the current certificate is kept when they fail to load
+func (r *certReloader) Reload() error {
+	st, err := r.load()
+	recordOutcome(MeasureCertReloads, err)
+	if err != nil {
+		r.logger.Printf("Failed to reload TLS certificates, keeping the current ones: %v", err)
+		return err
+	}
+	r.install(st)
+	return nil
+}
+
+// install serves the certificates loaded from the files
+func (r *certReloader) install(st *certState) {
+	r.current.Store(st)
+	r.applyTicketKeys()
+	r.logger.Printf("Reloaded TLS certificates from %v", r.files())
+}
+
+func (r *certReloader) applyTicketKeys() {
+	if keys := r.state().ticketKeys; r.tlsConfig != nil && keys != nil {
+		r.tlsConfig.SetSessionTicketKeys(keys)
+	}
+}
+
+func (r *certReloader) watch() {
+	if r.interval < 0 {
+		return
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.reloadIfChanged()
+		}
+	}
+}
+
+func (r *certReloader) stop() {
+	r.stopOnce.Do(func() { close(r.done) })
+}
+
+// reloadIfChanged reloads the files when they changed, or on every check once the revocation data expired
+func (r *certReloader) reloadIfChanged() {
+	stamps := r.stat()
+	changed := false
+	for f, st := range stamps {
+		if r.stamps[f] != st {
+			changed = true
+		}
+	}
+	expiry := r.state().expiry()
+	expired := !expiry.IsZero() && time.Now().After(expiry)
+	if !changed && !expired {
+		return
+	}
+	r.stamps = stamps
+	if err := r.Reload(); err != nil && expired {
+		r.logger.Printf("TLS revocation data expired at %s: the expired CRLs are still enforced, "+
+			"the expired OCSP responses aren't stapled anymore", expiry)
+	}
+}
+
+// stat follows symlinks, so an atomic swap of a mounted secret counts as a change
+func (r *certReloader) stat() map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, f := range r.files() {
+		if fi, err := os.Stat(f); err == nil {
+			stamps[f] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+		} else {
+			stamps[f] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+func (r *certReloader) files() []string {
+	var files []string
+	for _, p := range r.pairs {
+		files = append(files, p.cert, p.key)
+	}
+	if r.certDir != "" {
+		// pairs added to or removed from the directory change its timestamp
+		files = append(files, r.certDir)
+		found, _ := findKeyPairs(r.certDir)
+		for _, p := range found {
+			files = append(files, p.cert, p.key)
+		}
+	}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	if r.ticketKeysFile != "" {
+		files = append(files, r.ticketKeysFile)
+	}
+	files = append(files, r.crlFiles...)
+	files = append(files, r.ocspFiles...)
+	return files
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pool, _, err := loadCAs(file)
+	return pool, err
+}
+
+// loadCAs returns the certificates of a PEM bundle, both as a pool and parsed
+func loadCAs(file string) (*x509.CertPool, []*x509.Certificate, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	var cas []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse CA certificate in %s: %v", file, err)
+		}
+		pool.AddCert(ca)
+		cas = append(cas, ca)
+	}
+	if len(cas) == 0 {
+		return nil, nil, fmt.Errorf("no CA certificates found in %s", file)
+	}
+	return pool, cas, nil
+}
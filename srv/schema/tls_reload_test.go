@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate and its key for the given names
+func writeTestCert(t *testing.T, dir, name string, dnsNames ...string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func leafName(t *testing.T, r *certReloader) string {
+	t.Helper()
+	c, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "server")
+
+	r, err := newCertReloader(certFiles{pairs: []keyPair{{cert: certFile, key: keyFile}}, caFile: certFile}, -1, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.state().clientCAs == nil {
+		t.Fatal("expected the client CAs to be loaded")
+	}
+
+	// rotate the pair
+	newCert, newKey := writeTestCert(t, t.TempDir(), "rotated")
+	copyFile(t, newCert, certFile)
+	copyFile(t, newKey, keyFile)
+	bumpModTime(t, certFile, keyFile)
+	r.reloadIfChanged()
+	if got := leafName(t, r); got != "rotated" {
+		t.Fatalf("certificate was not reloaded: got %s want %s", got, "rotated")
+	}
+
+	// a broken pair keeps the current certificate
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	bumpModTime(t, keyFile)
+	r.reloadIfChanged()
+	if got := leafName(t, r); got != "rotated" {
+		t.Fatalf("broken certificate replaced the current one: got %s want %s", got, "rotated")
+	}
+}
+
+func copyFile(t *testing.T, from, to string) {
+	t.Helper()
+	b, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(to, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bumpModTime makes a change visible even on file systems with a coarse timestamp resolution
+func bumpModTime(t *testing.T, files ...string) {
+	t.Helper()
+	ts := time.Now().Add(time.Duration(len(files)) * time.Minute)
+	for _, f := range files {
+		if err := os.Chtimes(f, ts, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
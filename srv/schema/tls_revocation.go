@@ -0,0 +1,142 @@
+package schema
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// revocationList holds the revoked serials of all loaded CRLs, by issuer
+type revocationList struct {
+	revoked map[string]time.Time
+	// nextUpdate is the earliest next update of the CRLs, zero when none has one
+	nextUpdate time.Time
+}
+
+func revocationKey(rawIssuer []byte, serial fmt.Stringer) string {
+	return string(rawIssuer) + "/" + serial.String()
+}
+
+// loadCRLs parses PEM or DER encoded CRLs, each one has to be signed by one of the client CAs and be current,
+// a CRL past its next update may miss the latest revocations
+func loadCRLs(files []string, issuers []*x509.Certificate) (*revocationList, error) {
+	rl := &revocationList{revoked: make(map[string]time.Time)}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CRL: %v", err)
+		}
+		if block, _ := pem.Decode(b); block != nil {
+			b = block.Bytes
+		}
+		crl, err := x509.ParseRevocationList(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CRL %s: %v", file, err)
+		}
+
+		var issuer *x509.Certificate
+		for _, ca := range issuers {
+			if crl.CheckSignatureFrom(ca) == nil {
+				issuer = ca
+				break
+			}
+		}
+		if issuer == nil {
+			return nil, fmt.Errorf("CRL %s isn't signed by any of the client CAs", file)
+		}
+		if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+			return nil, fmt.Errorf("CRL %s expired at %s", file, crl.NextUpdate)
+		}
+		rl.nextUpdate = earliest(rl.nextUpdate, crl.NextUpdate)
+
+		for _, entry := range crl.RevokedCertificates {
+			rl.revoked[revocationKey(crl.RawIssuer, entry.SerialNumber)] = entry.RevocationTime
+		}
+	}
+	return rl, nil
+}
+
+func (rl *revocationList) isRevoked(cert *x509.Certificate) bool {
+	_, ok := rl.revoked[revocationKey(cert.RawIssuer, cert.SerialNumber)]
+	return ok
+}
+
+// verifyPeerCertificate is used as tls.Config.VerifyPeerCertificate, it rejects client certificates revoked
+// by the current CRLs, anywhere in the verified chain
+func (r *certReloader) verifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	rl := r.state().crl
+	if rl == nil {
+		return nil
+	}
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if rl.isRevoked(cert) {
+				r.logger.Printf("Rejected revoked client certificate serial=%s subject=%q", cert.SerialNumber, cert.Subject.String())
+				return errors.New("client certificate has been revoked")
+			}
+		}
+	}
+	return nil
+}
+
+// attachOCSPStaples staples the pre-fetched OCSP responses to the certificates they were issued for, and returns
+// the next update of the responses, past which they aren't stapled anymore
+func attachOCSPStaples(set *certSet, files []string) (map[*tls.Certificate]time.Time, error) {
+	expiry := make(map[*tls.Certificate]time.Time)
+	for _, file := range files {
+		der, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OCSP response: %v", err)
+		}
+		resp, err := ocsp.ParseResponse(der, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OCSP response %s: %v", file, err)
+		}
+
+		stapled := false
+		for _, cert := range set.all {
+			if cert.Leaf.SerialNumber.Cmp(resp.SerialNumber) != 0 {
+				continue
+			}
+			// verify the responder signature when the issuer is part of the chain
+			if len(cert.Certificate) > 1 {
+				issuer, err := x509.ParseCertificate(cert.Certificate[1])
+				if err != nil {
+					return nil, err
+				}
+				if resp, err = ocsp.ParseResponseForCert(der, cert.Leaf, issuer); err != nil {
+					return nil, fmt.Errorf("invalid OCSP response %s: %v", file, err)
+				}
+			}
+			if resp.Status != ocsp.Good {
+				return nil, fmt.Errorf("OCSP response %s doesn't report certificate %s as good", file, resp.SerialNumber)
+			}
+			if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+				return nil, fmt.Errorf("OCSP response %s expired at %s", file, resp.NextUpdate)
+			}
+			cert.OCSPStaple = der
+			if !resp.NextUpdate.IsZero() {
+				expiry[cert] = resp.NextUpdate
+			}
+			stapled = true
+		}
+		if !stapled {
+			return nil, fmt.Errorf("OCSP response %s doesn't match any certificate", file)
+		}
+	}
+	return expiry, nil
+}
+
+// earliest returns the earliest of the times which aren't zero
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
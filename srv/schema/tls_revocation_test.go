@@ -0,0 +1,186 @@
+package schema
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestCRLRejectsRevokedClient(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, err := newDevCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, pemBlock("CERTIFICATE", ca.Raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, _, err := newDevLeaf(ca, caKey, []string{"client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	client, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeCRL := func(nextUpdate time.Time, serials ...*big.Int) string {
+		tmpl := &x509.RevocationList{
+			Number:     big.NewInt(time.Now().UnixNano()),
+			ThisUpdate: nextUpdate.Add(-2 * time.Hour),
+			NextUpdate: nextUpdate,
+		}
+		for _, s := range serials {
+			tmpl.RevokedCertificates = append(tmpl.RevokedCertificates, pkix.RevokedCertificate{SerialNumber: s, RevocationTime: time.Now()})
+		}
+		der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, "ca.crl")
+		if err := os.WriteFile(file, pemBlock("X509 CRL", der), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	crlFile := writeCRL(time.Now().Add(time.Hour))
+	var logs bytes.Buffer
+	r, err := newCertReloader(certFiles{caFile: caFile, crlFiles: []string{crlFile}}, -1, log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains := [][]*x509.Certificate{{client, ca}}
+	if err := r.verifyPeerCertificate(nil, chains); err != nil {
+		t.Fatalf("valid client rejected: %v", err)
+	}
+
+	// revoking the client takes effect on reload
+	writeCRL(time.Now().Add(time.Hour), client.SerialNumber)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.verifyPeerCertificate(nil, chains); err == nil {
+		t.Fatal("revoked client accepted")
+	}
+
+	// a stale CRL is refused on load and reload, the current one is kept
+	writeCRL(time.Now().Add(-time.Minute))
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected an error for a stale CRL on reload")
+	}
+	if err := r.verifyPeerCertificate(nil, chains); err == nil {
+		t.Fatal("revoked client accepted after a failed reload")
+	}
+	if _, err := loadCRLs([]string{crlFile}, []*x509.Certificate{ca}); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("wrong error for a stale CRL: got %v", err)
+	}
+
+	// once the current CRL expired too, every check reloads and reports it while still enforcing it
+	r.state().crl.nextUpdate = time.Now().Add(-time.Second)
+	for i := 0; i < 2; i++ {
+		logs.Reset()
+		r.reloadIfChanged()
+		if !strings.Contains(logs.String(), "expired CRLs are still enforced") {
+			t.Fatalf("expired CRL not reported on check %d: %q", i+1, logs.String())
+		}
+	}
+	if err := r.verifyPeerCertificate(nil, chains); err == nil {
+		t.Fatal("revoked client accepted once the CRL expired")
+	}
+
+	// a CRL from another CA is refused
+	other, _, err := newDevCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCRLs([]string{crlFile}, []*x509.Certificate{other}); err == nil {
+		t.Fatal("expected an error for a CRL of an unknown issuer")
+	}
+}
+
+func TestAttachOCSPStaples(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, err := newDevCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM, err := newDevLeaf(ca, caKey, []string{"example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, append(certPEM, pemBlock("CERTIFICATE", ca.Raw)...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeResponse := func(status int) string {
+		der, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       status,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, "tls.ocsp")
+		if err := os.WriteFile(file, der, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	ocspFile := writeResponse(ocsp.Good)
+	r, err := newCertReloader(certFiles{pairs: []keyPair{{certFile, keyFile}}, ocspFiles: []string{ocspFile}}, -1, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.OCSPStaple) == 0 {
+		t.Fatal("OCSP response was not stapled")
+	}
+
+	// past its next update the response isn't stapled anymore
+	r.state().staplesExpiry[c] = time.Now().Add(-time.Second)
+	expired, err := r.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired.OCSPStaple) != 0 || len(c.OCSPStaple) == 0 {
+		t.Fatal("expired OCSP response still stapled")
+	}
+
+	writeResponse(ocsp.Revoked)
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected an error for a revoked OCSP response")
+	}
+}
@@ -0,0 +1,121 @@
+package schema
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type keyPair struct {
+	cert string
+	key  string
+}
+
+// parseKeyPair reads a "certificate,key" flag value
+func parseKeyPair(v string) (keyPair, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return keyPair{}, fmt.Errorf("expected a certificate and a key separated by a comma, got %q", v)
+	}
+	return keyPair{cert: strings.TrimSpace(parts[0]), key: strings.TrimSpace(parts[1])}, nil
+}
+
+// findKeyPairs looks for <name>.crt with <name>.key, and <name>.pem with <name>-key.pem in the directory
+func findKeyPairs(dir string) ([]keyPair, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate directory: %v", err)
+	}
+
+	var pairs []keyPair
+	for _, e := range entries {
+		name := e.Name()
+		var key string
+		switch {
+		case strings.HasSuffix(name, ".crt"):
+			key = strings.TrimSuffix(name, ".crt") + ".key"
+		case strings.HasSuffix(name, ".pem") && !strings.HasSuffix(name, "-key.pem"):
+			key = strings.TrimSuffix(name, ".pem") + "-key.pem"
+		default:
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, key)); err != nil {
+			continue
+		}
+		pairs = append(pairs, keyPair{cert: filepath.Join(dir, name), key: filepath.Join(dir, key)})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].cert < pairs[j].cert })
+	return pairs, nil
+}
+
+// certSet picks a certificate by SNI server name: exact names first, then wildcards, then the default
+type certSet struct {
+	byName map[string]*tls.Certificate
+	def    *tls.Certificate
+	all    []*tls.Certificate
+}
+
+func loadCertSet(pairs []keyPair) (*certSet, error) {
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no TLS certificates found")
+	}
+
+	set := &certSet{byName: make(map[string]*tls.Certificate)}
+	for _, p := range pairs {
+		cert, err := tls.LoadX509KeyPair(p.cert, p.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate and key %s: %v", p.cert, err)
+		}
+		if err := set.add(&cert); err != nil {
+			return nil, fmt.Errorf("failed to parse TLS certificate %s: %v", p.cert, err)
+		}
+	}
+	return set, nil
+}
+
+// add indexes the certificate by its DNS names, or its common name when it has none.
+// The first certificate added for a name wins, the first certificate overall is the default.
+func (s *certSet) add(cert *tls.Certificate) error {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+	for _, n := range names {
+		n = strings.ToLower(n)
+		if _, ok := s.byName[n]; !ok {
+			s.byName[n] = cert
+		}
+	}
+	if s.def == nil {
+		s.def = cert
+	}
+	s.all = append(s.all, cert)
+	return nil
+}
+
+func (s *certSet) forName(name string) *tls.Certificate {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return s.def
+	}
+	if c, ok := s.byName[name]; ok {
+		return c
+	}
+	// a wildcard only covers a single label
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if c, ok := s.byName["*"+name[i:]]; ok {
+			return c
+		}
+	}
+	return s.def
+}
@@ -0,0 +1,57 @@
+package schema
+
+import (
+	"testing"
+)
+
+func TestCertSetForName(t *testing.T) {
+	dir := t.TempDir()
+	defCert, defKey := writeTestCert(t, dir, "default", "default.test")
+	wildCert, wildKey := writeTestCert(t, dir, "wildcard", "*.example.com")
+	apiCert, apiKey := writeTestCert(t, dir, "api", "api.example.com")
+
+	set, err := loadCertSet([]keyPair{
+		{cert: defCert, key: defKey},
+		{cert: wildCert, key: wildKey},
+		{cert: apiCert, key: apiKey},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serverName string
+		expected   string
+	}{
+		{"api.example.com", "api"},
+		{"API.Example.com.", "api"},
+		{"www.example.com", "wildcard"},
+		{"a.b.example.com", "default"},
+		{"example.com", "default"},
+		{"unknown.test", "default"},
+		{"", "default"},
+	}
+	for _, tt := range tests {
+		got := set.forName(tt.serverName).Leaf.Subject.CommonName
+		if got != tt.expected {
+			t.Fatalf("wrong certificate for %q: got %s want %s", tt.serverName, got, tt.expected)
+		}
+	}
+}
+
+func TestFindKeyPairs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "one", "one.test")
+	writeTestCert(t, dir, "two", "two.test")
+	pairs, err := findKeyPairs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 {
+		t.Fatalf("wrong number of pairs: got %d want %d", len(pairs), 2)
+	}
+
+	if _, err := parseKeyPair("only-a-cert.pem"); err == nil {
+		t.Fatal("expected an error for a value without a key")
+	}
+}
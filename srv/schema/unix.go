@@ -0,0 +1,196 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	flag "github.com/spf13/pflag"
+	"golang.org/x/sync/errgroup"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// UnixFlg serves http over a unix domain socket, e.g. behind a local sidecar proxy
+type UnixFlg struct {
+	Prefix       string
+	Path         string
+	Mode         os.FileMode
+	ListenLimit  int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	Handler      http.Handler
+
+	listenOnce sync.Once
+	listener   net.Listener
+	timeouts   liveTimeouts
+}
+
+func (u *UnixFlg) RegisterFlags(fs *flag.FlagSet) {
+	prefix := u.Prefix
+
+	if u.Mode == 0 {
+		u.Mode = 0660
+	}
+
+	fs.StringVar(&u.Path, FlagName(prefix, "socket-path"), u.Path, "the unix socket to listen on")
+	fs.Var((*fileMode)(&u.Mode), FlagName(prefix, "socket-mode"), "the file permissions of the unix socket, in octal")
+	fs.IntVar(&u.ListenLimit, FlagName(prefix, "socket-listen-limit"), 0, "limit the number of outstanding requests")
+	fs.DurationVar(&u.ReadTimeout, FlagName(prefix, "socket-read-timeout"), 30*time.Second, "maximum duration before timing out read of the request")
+	fs.DurationVar(&u.WriteTimeout, FlagName(prefix, "socket-write-timeout"), 30*time.Second, "maximum duration before timing out write of the response")
+}
+
+func (u *UnixFlg) Listener() (net.Listener, error) {
+	var errMsg string
+	u.listenOnce.Do(func() {
+		if u.Path == "" {
+			errMsg = fmt.Sprintf("the required flag %q was not specified", FlagName(u.Prefix, "socket-path"))
+			return
+		}
+
+		// the service manager owns inherited sockets, leave the socket file alone
+		l, ok := InheritedListener(u.Prefix, u.Scheme())
+		if !ok {
+			var err error
+			if l, err = u.bind(); err != nil {
+				u.listener = nil
+				errMsg = err.Error()
+				return
+			}
+		}
+
+		// the limit can change on reload, zero is unlimited
+		l = limitListener(l, u.ListenLimit)
+
+		u.listener = l
+	})
+
+	if u.listener == nil {
+		u.listenOnce = sync.Once{}
+		return nil, errors.New(errMsg)
+	}
+
+	return u.listener, nil
+}
+
+// bind creates the socket file, replacing a stale one, and applies the file permissions
+func (u *UnixFlg) bind() (net.Listener, error) {
+	if err := removeStaleSocket(u.Path); err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", u.Path)
+	if err != nil {
+		return nil, err
+	}
+	// remove the socket file when the listener gets closed on shutdown
+	l.(*net.UnixListener).SetUnlinkOnClose(true)
+
+	if u.Mode != 0 {
+		if err := os.Chmod(u.Path, u.Mode); err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (u *UnixFlg) Serve(s ServerConfig, eg *errgroup.Group) (*http.Server, error) {
+	listener, err := u.Listener()
+	if err != nil {
+		return nil, err
+	}
+
+	unixSrv := &http.Server{
+		MaxHeaderBytes: s.MaxHeaderSize,
+		ReadTimeout:    u.ReadTimeout,
+		WriteTimeout:   u.WriteTimeout,
+		Handler:        s.Handler,
+	}
+
+	if int64(s.CleanupTimeout) > 0 {
+		unixSrv.IdleTimeout = s.CleanupTimeout
+	}
+
+	if u.Handler != nil { // local values take precedence over the default
+		unixSrv.Handler = u.Handler
+	}
+	// the timeouts can change on reload
+	unixSrv.Handler = u.timeouts.wrap(unixSrv.Handler)
+
+	if s.Callbacks != nil {
+		s.Callbacks.ConfigureListener(unixSrv, u.Scheme(), u.Path)
+	}
+	listener = s.Conns.attach(unixSrv, listener)
+
+	p := u.Name()
+	s.Logger.Printf("Serving at %s://%s", p, u.Path)
+	eg.Go(func() error {
+		if uerr := unixSrv.Serve(listener); uerr != nil && uerr != http.ErrServerClosed {
+			s.Logger.Printf("Error stopping %s listener: %v", p, uerr)
+			return uerr
+		}
+		s.Logger.Printf("Stopped serving at %s://%s", p, u.Path)
+		return nil
+	})
+
+	return unixSrv, nil
+}
+
+func (u *UnixFlg) Scheme() string {
+	return SchemeUnix
+}
+
+// Name identifies the listener in logs and when handing its socket over, defaults to the scheme
+func (u *UnixFlg) Name() string {
+	return listenerName(u.Prefix, u.Scheme())
+}
+
+func (u *UnixFlg) String() string {
+	return fmt.Sprintf("Prefix: %s,Path: %s,Mode: %#o,ListenLimit: %d,ReadTimeout: %d,WriteTimeout: %d\n",
+		u.Prefix, u.Path, uint32(u.Mode), u.ListenLimit, u.ReadTimeout, u.WriteTimeout)
+}
+
+// removeStaleSocket deletes a socket file left behind by a process that didn't shut down cleanly.
+// It refuses to touch regular files or sockets that still accept connections.
+func removeStaleSocket(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a unix socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("unix socket %s is already in use", path)
+	}
+	return os.Remove(path)
+}
+
+// fileMode is a pflag value for octal file permissions
+type fileMode os.FileMode
+
+func (m *fileMode) String() string {
+	return fmt.Sprintf("%#o", uint32(*m))
+}
+
+func (m *fileMode) Set(value string) error {
+	v, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return err
+	}
+	*m = fileMode(v)
+	return nil
+}
+
+func (m *fileMode) Type() string {
+	return "file-mode"
+}
@@ -0,0 +1,87 @@
+package schema
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestUnixFlgServe(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "app.sock")
+
+	// leave a stale socket behind, like a crashed process would
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	u := &UnixFlg{Prefix: "app", Path: sock, Mode: 0600}
+	var buf bytes.Buffer
+	eg := new(errgroup.Group)
+	hs, err := u.Serve(ServerConfig{
+		Logger: log.New(&buf, "", 0),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("OK"))
+		}),
+	}, eg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("wrong socket mode: got %#o want %#o", fi.Mode().Perm(), 0600)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "OK" {
+		t.Fatalf("wrong body: got %q want %q", body, "OK")
+	}
+
+	if err := hs.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Fatalf("socket file was not removed on shutdown: %v", err)
+	}
+}
+
+func TestUnixFlgSocketInUse(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	u := &UnixFlg{Path: sock}
+	if _, err := u.Listener(); err == nil {
+		t.Fatal("expected an error for a socket that is still in use")
+	}
+}
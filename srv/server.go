@@ -2,13 +2,14 @@ package srv
 
 import (
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"sync/atomic"
-	"syscall"
 	"time"
 
 	"github.com/a-h/hsts"
@@ -20,6 +21,14 @@ import (
 
 var defaultSchemes []string
 
+// forceExitGrace bounds the wait for the connections to close on a forced exit
+const forceExitGrace = time.Second
+
+// exit is replaced in tests
+var exit = os.Exit
+
+const defaultShutdownTimeout = 15 * time.Second
+
 func init() {
 	defaultSchemes = []string{
 		schema.SchemeHTTP,
@@ -29,34 +38,60 @@ func init() {
 var (
 	enabledListeners []string
 	cleanupTimout    time.Duration
+	shutdownTimeout  time.Duration
+	drainDelay       time.Duration
 	maxHeaderSize    ByteSize
 
+	// DefaultHTTPFlags and DefaultTLSFlags are the listeners of the package level flags, Config has its own
 	DefaultHTTPFlags schema.HTTPFlg
 	DefaultTLSFlags  schema.TLSFlg
+
+	// httpEnvErr and tlsEnvErr report the invalid environment variables of the default listeners,
+	// binding them returns the error
+	httpEnvErr, tlsEnvErr error
 )
 
 func init() {
 	maxHeaderSize = *NewByteSize(1000000)
-	DefaultHTTPFlags.Host = stringEnvOverride(DefaultHTTPFlags.Host, "localhost", "HOST")
-	DefaultHTTPFlags.Port = intEnvOverride(DefaultHTTPFlags.Port, 8080, "PORT")
-	DefaultTLSFlags.Host = stringEnvOverride(DefaultTLSFlags.Host, "", "TLS_HOST")
-	DefaultTLSFlags.Port = intEnvOverride(DefaultTLSFlags.Port, 8443, "TLS_PORT")
-	DefaultTLSFlags.Cert = stringEnvOverride(DefaultTLSFlags.Cert, "", "TLS_CERTIFICATE")
-	DefaultTLSFlags.CertKey = stringEnvOverride(DefaultTLSFlags.CertKey, "", "TLS_PRIVATE_KEY")
-	DefaultTLSFlags.CACert = stringEnvOverride(DefaultTLSFlags.CACert, "", "TLS_CA_CERTIFICATE")
+	var errs [7]error
+	DefaultHTTPFlags.Host, errs[0] = stringEnvOverride(DefaultHTTPFlags.Host, "localhost", "HOST")
+	DefaultHTTPFlags.Port, errs[1] = intEnvOverride(DefaultHTTPFlags.Port, 8080, "PORT")
+	DefaultTLSFlags.Host, errs[2] = stringEnvOverride(DefaultTLSFlags.Host, "", "TLS_HOST")
+	DefaultTLSFlags.Port, errs[3] = intEnvOverride(DefaultTLSFlags.Port, 8443, "TLS_PORT")
+	DefaultTLSFlags.Cert, errs[4] = stringEnvOverride(DefaultTLSFlags.Cert, "", "TLS_CERTIFICATE")
+	DefaultTLSFlags.CertKey, errs[5] = stringEnvOverride(DefaultTLSFlags.CertKey, "", "TLS_PRIVATE_KEY")
+	DefaultTLSFlags.CACert, errs[6] = stringEnvOverride(DefaultTLSFlags.CACert, "", "TLS_CA_CERTIFICATE")
+	httpEnvErr = errors.Join(errs[:2]...)
+	tlsEnvErr = errors.Join(errs[2:]...)
 }
 
 type (
 	defaultServer struct {
 		opts   *options
 		CleanupTimeout   time.Duration
+		ShutdownTimeout  time.Duration
+		DrainDelay       time.Duration
 		MaxHeaderSize    ByteSize
 
 		listening    bool
+		started      int32
 		shutdown     chan struct{}
 		shuttingDown int32
 		interrupted  bool
 		interrupt    chan os.Signal
+		forceCtx     context.Context
+		forceStop    context.CancelFunc
+		restart      chan os.Signal
+
+		// appHandler is the request handler without HSTS, for the paths exempt from the https redirect
+		appHandler http.Handler
+
+		ready    chan struct{}
+		drained  chan struct{}
+		conns    *schema.ConnRegistry
+		done     chan struct{}
+		doneOnce sync.Once
+		err      error
 	}
 
 	hstsConfig struct {
@@ -70,12 +105,49 @@ type (
 )
 
 func New(opts ...Option) Server {
+	o := newDefaultWithOptions(opts...)
+	cfg := o.config
+	if cfg == nil {
+		// the package level flags
+		cfg = &Config{
+			CleanupTimeout:  cleanupTimout,
+			ShutdownTimeout: shutdownTimeout,
+			DrainDelay:      drainDelay,
+			MaxHeaderSize:   maxHeaderSize,
+		}
+	}
+
 	s := &defaultServer{
-		opts: newDefaultWithOptions(opts...),
-		CleanupTimeout:   cleanupTimout,
-		MaxHeaderSize:    maxHeaderSize,
+		opts: o,
+		CleanupTimeout:   cfg.CleanupTimeout,
+		ShutdownTimeout:  cfg.ShutdownTimeout,
+		DrainDelay:       cfg.DrainDelay,
+		MaxHeaderSize:    cfg.MaxHeaderSize,
 		shutdown:         make(chan struct{}),
 		interrupt:        make(chan os.Signal, 1),
+		restart:          make(chan os.Signal, 1),
+		ready:            make(chan struct{}),
+		done:             make(chan struct{}),
+		drained:          make(chan struct{}),
+		conns:            schema.NewConnRegistry(),
+	}
+	s.appHandler = s.opts.handler
+	s.forceCtx, s.forceStop = context.WithCancel(context.Background())
+
+	if s.opts.cleanupTimeout != nil {
+		s.CleanupTimeout = *s.opts.cleanupTimeout
+	}
+	if s.opts.maxHeaderSize != nil {
+		s.MaxHeaderSize = *s.opts.maxHeaderSize
+	}
+	if s.opts.shutdownTimeout != nil {
+		s.ShutdownTimeout = *s.opts.shutdownTimeout
+	}
+	if s.ShutdownTimeout <= 0 {
+		s.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if s.opts.drainDelay != nil {
+		s.DrainDelay = *s.opts.drainDelay
 	}
 
 	if s.opts.hsts != nil {
@@ -88,37 +160,129 @@ func New(opts ...Option) Server {
 }
 
 func (s *defaultServer) Serve() error {
+	return s.ServeContext(context.Background())
+}
+
+// ServeContext serves until Shutdown is called, a shutdown signal is received or the context is done.
+// A server serves once, later calls return an error.
+func (s *defaultServer) ServeContext(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		return errors.New("server already started")
+	}
+	err := s.serve(ctx)
+	s.doneOnce.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+	return err
+}
+
+func (s *defaultServer) serve(ctx context.Context) error {
 	if !s.listening {
 		if err := s.Listen(); err != nil {
 			return err
 		}
 	}
 
-	once := new(sync.Once)
-	signalNotify(s.interrupt)
-	go handleInterrupt(once, s)
+	if policy := s.opts.signalPolicy(); !policy.Disabled {
+		signal.Notify(s.interrupt, policy.Stop...)
+		defer signal.Stop(s.interrupt)
+		go s.handleSignals(policy)
+	}
 
-	servers := []*http.Server{}
+	if len(s.opts.restartSignals) > 0 {
+		signal.Notify(s.restart, s.opts.restartSignals...)
+		defer signal.Stop(s.restart)
+		go s.handleRestart()
+	}
+
+	if rc := s.opts.reload; rc != nil && len(rc.signals) > 0 {
+		signal.Notify(rc.signal, rc.signals...)
+		defer signal.Stop(rc.signal)
+		go s.handleReload()
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.opts.logger.Printf("Shutting down... ")
+			_ = s.Shutdown()
+		case <-s.shutdown:
+		}
+	}()
+
+	if err := s.runHooks(ctx, "start", s.opts.hooks.onStart, false, true); err != nil {
+		s.closeListeners()
+		_ = s.Shutdown()
+		return err
+	}
 
 	serveGroup, _ := errgroup.WithContext(context.Background())
+	servers, err := s.startServers(serveGroup)
+
+	// watch for shutdown once all servers are known, so none of them is missed
 	serveGroup.Go(func() error {
-		return s.handleShutdown(&servers)
+		return s.handleShutdown(servers)
 	})
 
+	if err != nil {
+		// stop the servers which already started
+		_ = s.Shutdown()
+		_ = serveGroup.Wait()
+		return err
+	}
+
+	// let the process we took the listeners over from know it can drain now
+	notifyReady()
+	// the ready hooks are done before anyone waiting on Ready can start a shutdown
+	_ = s.runHooks(ctx, "ready", s.opts.hooks.onReady, false, false)
+	close(s.ready)
+
+	if err := serveGroup.Wait(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// startServers serves the regular listeners of the enabled schemes, then the system listeners.
+// On error, it returns the servers which were started so far.
+func (s *defaultServer) startServers(serveGroup *errgroup.Group) ([]*http.Server, error) {
+	var redirectPort int
+	if s.opts.httpsRedirect != nil && s.hasScheme(schema.SchemeHTTP) {
+		port, err := s.tlsPort()
+		if err != nil {
+			return nil, err
+		}
+		redirectPort = port
+	}
+
+	servers := []*http.Server{}
+
 	// Start regular listeners
 	for _, server := range s.opts.listeners {
 		if s.hasScheme(server.Scheme()) {
+			handler := s.opts.handler
+			if server.Scheme() == schema.SchemeHTTP {
+				if s.opts.httpsRedirect != nil {
+					handler = s.opts.httpsRedirect.redirectHandler(redirectPort, s.appHandler)
+				}
+				var err error
+				if handler, err = s.challengeHandler(handler); err != nil {
+					return servers, err
+				}
+			}
 			sc := schema.ServerConfig{
 				Callbacks:      s.opts.callbacks,
 				CleanupTimeout: s.CleanupTimeout,
 				MaxHeaderSize:  int(s.MaxHeaderSize.Get()),
-				Handler:        s.opts.handler,
+				Handler:        handler,
 				Logger:         s.opts.logger,
+				Conns:          s.conns,
 			}
 			if hs, err := server.Serve(sc, serveGroup); err == nil {
 				servers = append(servers, hs)
 			} else {
-				return err
+				return servers, err
 			}
 		}
 	}
@@ -130,18 +294,15 @@ func (s *defaultServer) Serve() error {
 			MaxHeaderSize:  int(s.MaxHeaderSize.Get()),
 			Handler:        s.opts.systemHandler,
 			Logger:         s.opts.logger,
+			Conns:          s.conns,
 		}
 		if hs, err := server.Serve(sc, serveGroup); err == nil {
 			servers = append(servers, hs)
 		} else {
-			return err
+			return servers, err
 		}
 	}
-
-	if err := serveGroup.Wait(); err != nil {
-		return err
-	}
-	return nil
+	return servers, nil
 }
 
 // Listen creates the listeners for the server
@@ -150,7 +311,7 @@ func (s *defaultServer) Listen() error {
 		if !s.hasScheme(server.Scheme()) {
 			continue
 		}
-		_, err := server.Listener()
+		_, err := listenDefault(server)
 		if err != nil {
 			return err
 		}
@@ -159,6 +320,15 @@ func (s *defaultServer) Listen() error {
 	return nil
 }
 
+// closeListeners releases the listeners when the servers won't start
+func (s *defaultServer) closeListeners() {
+	for _, l := range s.activeListeners() {
+		if nl, err := l.Listener(); err == nil {
+			_ = nl.Close()
+		}
+	}
+}
+
 // Shutdown server and clean up resources
 func (s *defaultServer) Shutdown() error {
 	// ensure shutDown is performed only once
@@ -168,32 +338,145 @@ func (s *defaultServer) Shutdown() error {
 	return nil
 }
 
-func (s *defaultServer) handleShutdown(serversPtr *[]*http.Server) error {
+// handleShutdown runs the stopping hooks, keeps serving for DrainDelay, gives the servers ShutdownTimeout
+// to finish the requests in flight and closes the remaining connections. The stopped hooks and the on-shutdown
+// handlers run in any case, all errors are returned.
+func (s *defaultServer) handleShutdown(servers []*http.Server) error {
 	<-s.shutdown
 
-	servers := *serversPtr
+	stoppingErr := s.runHooks(s.forceCtx, "stopping", s.opts.hooks.onStopping, true, false)
+	s.drain()
+	err := s.stopServers(servers)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+	stoppedErr := s.runHooks(s.forceCtx, "stopped", s.opts.hooks.onStopped, true, false)
+	for _, run := range s.opts.onShutdown {
+		run()
+	}
+	return errors.Join(stoppingErr, err, stoppedErr)
+}
 
-	stGroup, stCtx := errgroup.WithContext(ctx)
+func (s *defaultServer) stopServers(servers []*http.Server) error {
+	// a forced stop cuts the grace period short
+	ctx, cancel := context.WithTimeout(s.forceCtx, s.ShutdownTimeout)
+	defer cancel()
 
+	var stGroup errgroup.Group
 	for _, srv := range servers {
 		server := srv // capture the loop variable
 		stGroup.Go(func() error {
-			return server.Shutdown(stCtx)
+			err := server.Shutdown(ctx)
+			if err == nil {
+				return nil
+			}
+			s.opts.logger.Printf("Graceful shutdown failed after %s, closing the remaining connections: %v", s.ShutdownTimeout, err)
+			if cerr := server.Close(); cerr != nil {
+				s.opts.logger.Printf("error closing connections: %v", cerr)
+			}
+			return fmt.Errorf("HTTP server shutdown: %v", err)
 		})
 	}
+	// cancel the streams right away, close the connections of the streams still running when the timeout expires
+	stGroup.Go(func() error {
+		if err := s.conns.Shutdown(ctx); err != nil {
+			s.opts.logger.Printf("Streams still running after %s, closing their connections", s.ShutdownTimeout)
+			return fmt.Errorf("streams shutdown: %v", err)
+		}
+		return nil
+	})
+	return stGroup.Wait()
+}
 
-	if err := stGroup.Wait(); err != nil {
-		s.opts.logger.Fatalf("HTTP server Shutdown: %v", err)
-		return err
-	} else {
-		if s.opts.onShutdown != nil {
-			s.opts.onShutdown()
+// drain runs the on-draining handlers, like failing the readiness checks, and keeps serving for DrainDelay
+// so the load balancers have time to stop routing new requests
+func (s *defaultServer) drain() {
+	defer close(s.drained)
+	for _, h := range s.opts.onDraining {
+		h()
+	}
+	if s.DrainDelay > 0 {
+		s.opts.logger.Printf("Draining for %s before closing the listeners... ", s.DrainDelay)
+		select {
+		case <-time.After(s.DrainDelay):
+		case <-s.forceCtx.Done():
 		}
 	}
-	return nil
+}
+
+// PreStopHandler starts the shutdown and answers once the drain delay is over, for a Kubernetes preStop hook.
+// The requests in flight, this one included, are then given the shutdown timeout to complete.
+func (s *defaultServer) PreStopHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.opts.logger.Printf("Pre-stop hook called, shutting down... ")
+		if err := s.Shutdown(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		select {
+		case <-s.drained:
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+		}
+	})
+}
+
+// challengeHandler answers the ACME http-01 challenges of the enabled TLS listeners on the plain http listeners
+func (s *defaultServer) challengeHandler(next http.Handler) (http.Handler, error) {
+	for _, l := range s.opts.listeners {
+		if !s.hasScheme(l.Scheme()) {
+			continue
+		}
+		if c, ok := l.(interface {
+			HTTPChallengeHandler(http.Handler) (http.Handler, error)
+		}); ok {
+			var err error
+			if next, err = c.HTTPChallengeHandler(next); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return next, nil
+}
+
+// Addrs returns the bound addresses of the active listeners by name, the prefix or else the scheme.
+// It is empty until Listen has been called.
+func (s *defaultServer) Addrs() map[string]net.Addr {
+	addrs := make(map[string]net.Addr)
+	if !s.listening {
+		return addrs
+	}
+	for _, l := range s.activeListeners() {
+		nl, err := l.Listener()
+		if err != nil {
+			continue
+		}
+		addrs[listenerName(l)] = nl.Addr()
+	}
+	return addrs
+}
+
+// Conns tracks the connections of all listeners and the long-lived streams registered with schema.TrackStream
+func (s *defaultServer) Conns() *schema.ConnRegistry {
+	return s.conns
+}
+
+// Ready is closed once every listener is bound and served by its own goroutine, after the OnReady hooks
+func (s *defaultServer) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Done is closed once Serve returns, after shutdown completed or serving failed
+func (s *defaultServer) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err is the error Serve returned, nil while serving and after a clean shutdown
+func (s *defaultServer) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
 }
 
 // GetHandler returns a handler useful for testing
@@ -211,7 +494,10 @@ func (s *defaultServer) HTTPListener() (net.Listener, error) {
 			return l.Listener()
 		}
 	}
-	return DefaultHTTPFlags.Listener()
+	if s.opts.config != nil {
+		return s.opts.config.HTTP.Listener()
+	}
+	return listenDefault(&DefaultHTTPFlags)
 }
 
 // TLSListener returns the https listener
@@ -224,26 +510,58 @@ func (s *defaultServer) TLSListener() (net.Listener, error) {
 			return l.Listener()
 		}
 	}
-	return DefaultTLSFlags.Listener()
+	if s.opts.config != nil {
+		return s.opts.config.TLS.Listener()
+	}
+	return listenDefault(&DefaultTLSFlags)
 }
 
-func handleInterrupt(once *sync.Once, s *defaultServer) {
-	once.Do(func() {
-		for range s.interrupt {
-			if s.interrupted {
-				continue
-			}
-			s.opts.logger.Printf("Shutting down... ")
+// listenDefault binds the listener, unless it is one of the default listeners and its environment is invalid
+func listenDefault(l schema.ServerListener) (net.Listener, error) {
+	var err error
+	switch l {
+	case &DefaultHTTPFlags:
+		err = httpEnvErr
+	case &DefaultTLSFlags:
+		err = tlsEnvErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid environment: %v", err)
+	}
+	return l.Listener()
+}
+
+// handleSignals shuts down gracefully on the first stop signal, and with ForceExit, closes everything
+// and exits on the next one
+func (s *defaultServer) handleSignals(policy SignalPolicy) {
+	for {
+		var sig os.Signal
+		select {
+		case <-s.done:
+			return
+		case sig = <-s.interrupt:
+		}
+
+		if !s.interrupted {
+			s.opts.logger.Printf("Received %s, shutting down... ", sig)
 			s.interrupted = true
 			if err := s.Shutdown(); err != nil {
 				s.opts.logger.Printf("error during server shutdown: %v", err)
 			}
+			continue
+		}
+		if !policy.ForceExit {
+			continue
 		}
-	})
-}
 
-func signalNotify(interrupt chan<- os.Signal) {
-	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+		s.opts.logger.Printf("Received %s again, closing all connections and exiting... ", sig)
+		s.forceStop()
+		select {
+		case <-s.done:
+		case <-time.After(forceExitGrace):
+		}
+		exit(1)
+	}
 }
 
 func (s *defaultServer) hasScheme(scheme string) bool {
@@ -263,11 +581,12 @@ func (s *defaultServer) hasScheme(scheme string) bool {
 }
 
 
-// RegisterFlags to the specified pflag set
+// RegisterFlags to the specified pflag set, into the package level settings shared by all servers
+// which aren't configured with a Config.
+//
+// Deprecated: use NewConfig and Config.RegisterFlags, they don't share their settings with the other servers.
 func RegisterFlags(fs *flag.FlagSet) {
-	fs.StringSliceVar(&enabledListeners, "scheme", defaultSchemes, "the listeners to enable, this can be repeated and defaults to the schemes in the swagger spec")
-	fs.DurationVar(&cleanupTimout, "cleanup-timeout", 10*time.Second, "grace period for which to wait before shutting down the server")
-	fs.Var(&maxHeaderSize, "max-header-size", "controls the maximum number of bytes the server will read parsing the request header's keys and values, including the request line. It does not limit the size of the request body")
+	registerServerFlags(fs, "", &enabledListeners, &cleanupTimout, &drainDelay, &shutdownTimeout, &maxHeaderSize)
 
 	DefaultHTTPFlags.RegisterFlags(fs)
 	DefaultTLSFlags.RegisterFlags(fs)
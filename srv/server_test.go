@@ -0,0 +1,106 @@
+package srv
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+)
+
+func TestServerLifecycle(t *testing.T) {
+	s := New(
+		LogsWith(log.New(io.Discard, "", 0)),
+		EnablesSchemes(schema.SchemeHTTP),
+		WithListeners(&schema.HTTPFlg{Prefix: "app", Host: "127.0.0.1"}),
+		WithSystemListeners(&schema.HTTPFlg{Prefix: "system", Host: "127.0.0.1"}),
+	)
+	if len(s.Addrs()) != 0 {
+		t.Fatal("addresses reported before listening")
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	addrs := s.Addrs()
+	for _, name := range []string{"app", "system"} {
+		if _, port, err := schema.SplitHostPort(addrs[name].String()); err != nil || port == 0 {
+			t.Fatalf("wrong address for %s: got %v", name, addrs[name])
+		}
+	}
+
+	go func() { _ = s.Serve() }()
+	select {
+	case <-s.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server not ready")
+	}
+
+	if err := s.Serve(); err == nil || err.Error() != "server already started" {
+		t.Fatalf("wrong error serving twice: got %v", err)
+	}
+
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(20 * time.Second):
+		t.Fatal("server not done after shutdown")
+	}
+	if err := s.Serve(); err == nil {
+		t.Fatal("expected an error serving a stopped server")
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServeContextForcesClose(t *testing.T) {
+	app := &schema.HTTPFlg{Host: "127.0.0.1"}
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	shutdownRan := make(chan struct{})
+
+	s := New(
+		LogsWith(log.New(io.Discard, "", 0)),
+		EnablesSchemes(schema.SchemeHTTP),
+		WithListeners(app),
+		WithShutdownTimeout(100*time.Millisecond),
+		OnShutdown(func() { close(shutdownRan) }),
+		HandlesRequestsWith(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(inFlight)
+			<-release
+		})),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { _ = s.ServeContext(ctx) }()
+	<-s.Ready()
+
+	go func() {
+		resp, err := http.Get("http://" + s.Addrs()[schema.SchemeHTTP].String())
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-inFlight
+	cancel()
+
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server not done after the shutdown timeout")
+	}
+	if s.Err() == nil {
+		t.Fatal("expected an error for the request still in flight")
+	}
+	select {
+	case <-shutdownRan:
+	default:
+		t.Fatal("on-shutdown handlers didn't run")
+	}
+}
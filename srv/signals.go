@@ -0,0 +1,35 @@
+package srv
+
+import (
+	"os"
+	"syscall"
+)
+
+// SignalPolicy decides how the server reacts to process signals
+type SignalPolicy struct {
+	// Disabled leaves the signals alone, for servers embedded in a larger application or run by a supervisor
+	// which owns them. The server then only stops with Shutdown or the context of ServeContext.
+	Disabled bool
+	// Stop are the signals starting a graceful shutdown, SIGINT and SIGTERM when empty
+	Stop []os.Signal
+	// ForceExit closes all connections right away and exits the process with status 1
+	// when a stop signal is received during the shutdown
+	ForceExit bool
+}
+
+var defaultStopSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+var defaultReloadSignals = []os.Signal{syscall.SIGHUP}
+
+// signalPolicy returns the configured policy, stop signals default to SIGINT and SIGTERM
+// and a second one forces the exit
+func (o *options) signalPolicy() SignalPolicy {
+	if o.signals == nil {
+		return SignalPolicy{Stop: defaultStopSignals, ForceExit: true}
+	}
+	policy := *o.signals
+	if len(policy.Stop) == 0 {
+		policy.Stop = defaultStopSignals
+	}
+	return policy
+}
@@ -0,0 +1,57 @@
+//go:build unix
+
+package srv
+
+import (
+	"io"
+	"log"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv/schema"
+)
+
+func TestSecondSignalForcesExit(t *testing.T) {
+	exited := make(chan int, 1)
+	exit = func(code int) { exited <- code }
+	defer func() { exit = os.Exit }()
+
+	s := New(
+		LogsWith(log.New(io.Discard, "", 0)),
+		EnablesSchemes(schema.SchemeHTTP),
+		WithListeners(&schema.HTTPFlg{Host: "127.0.0.1"}),
+		WithSignalPolicy(SignalPolicy{Stop: []os.Signal{syscall.SIGUSR1}, ForceExit: true}),
+		WithDrainDelay(time.Minute), // a stuck drain
+	)
+	go func() { _ = s.Serve() }()
+	<-s.Ready()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-s.Done():
+		t.Fatal("server stopped before the drain delay")
+	default:
+	}
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Fatalf("wrong exit code: got %d want %d", code, 1)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second signal didn't force the exit")
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("server still running after the forced exit")
+	}
+}
@@ -0,0 +1,245 @@
+// Package srvtest starts go-srv servers on ephemeral ports for tests, and checks they shut down cleanly
+package srvtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv"
+	"github.com/gabibotos/go-srv/srv/schema"
+)
+
+var (
+	// ReadyTimeout bounds the wait for the server to be ready, and the requests of the clients
+	ReadyTimeout = 5 * time.Second
+	// ShutdownTimeout bounds the wait for the server to stop, and for its goroutines to exit
+	ShutdownTimeout = 20 * time.Second
+)
+
+// Server is a running server with an app and a system listener on 127.0.0.1
+type Server struct {
+	srv.Server
+
+	// AppURL and SystemURL are the base URLs of the listeners, like http://127.0.0.1:41234
+	AppURL    string
+	SystemURL string
+	// AppClient and SystemClient don't follow redirects and time out after ReadyTimeout
+	AppClient    *http.Client
+	SystemClient *http.Client
+	// Logs captures everything the server logs
+	Logs *LogRecorder
+
+	goroutines *goroutineTree
+}
+
+// Start runs a server built with the options on ephemeral ports, and blocks until it is serving.
+// The server is shut down when the test completes, and the test fails if it doesn't stop cleanly or leaves
+// goroutines behind. Only the goroutines started by this server and its handlers count, not those of
+// parallel tests.
+// The options come last, so they can replace the handlers or the logger.
+func Start(t testing.TB, opts ...srv.Option) *Server {
+	t.Helper()
+
+	app := &schema.HTTPFlg{Prefix: "app", Host: "127.0.0.1"}
+	system := &schema.HTTPFlg{Prefix: "system", Host: "127.0.0.1"}
+	logs := &LogRecorder{}
+	goroutines := newGoroutineTree()
+
+	var (
+		s   srv.Server
+		err error
+	)
+	goroutines.do(func() {
+		s = srv.New(append([]srv.Option{
+			srv.LogsWith(logs),
+			srv.WithSignalPolicy(srv.SignalPolicy{Disabled: true}),
+			srv.EnablesSchemes(schema.SchemeHTTP),
+			srv.WithListeners(app),
+			srv.WithSystemListeners(system),
+		}, opts...)...)
+		err = s.Listen()
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	addrs := s.Addrs()
+	ts := &Server{
+		Server:       s,
+		AppURL:       "http://" + addrs[app.Name()].String(),
+		SystemURL:    "http://" + addrs[system.Name()].String(),
+		AppClient:    newClient(),
+		SystemClient: newClient(),
+		Logs:         logs,
+		goroutines:   goroutines,
+	}
+	goroutines.do(func() {
+		go func() {
+			_ = s.Serve()
+		}()
+	})
+	t.Cleanup(func() {
+		ts.close(t)
+	})
+
+	select {
+	case <-s.Ready():
+	case <-s.Done():
+		t.Fatalf("server stopped before it was ready: %v", s.Err())
+	case <-time.After(ReadyTimeout):
+		t.Fatalf("server not ready after %s", ReadyTimeout)
+	}
+	return ts
+}
+
+func newClient() *http.Client {
+	return &http.Client{
+		Timeout: ReadyTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func (ts *Server) close(t testing.TB) {
+	t.Helper()
+
+	ts.AppClient.CloseIdleConnections()
+	ts.SystemClient.CloseIdleConnections()
+	if err := ts.Shutdown(); err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+
+	select {
+	case <-ts.Done():
+		if err := ts.Err(); err != nil {
+			t.Errorf("server stopped with an error: %v", err)
+		}
+	case <-time.After(ShutdownTimeout):
+		t.Errorf("server still serving %s after shutdown", ShutdownTimeout)
+		return
+	}
+	if msg, ok := ts.Logs.fatal(); ok {
+		t.Errorf("server logged a fatal error: %s", msg)
+	}
+
+	if leaked := ts.goroutines.wait(ShutdownTimeout); leaked != "" {
+		t.Errorf("goroutines of the server or its handlers still running after shutdown:\n%s", leaked)
+	}
+}
+
+// goroutineTree finds the goroutines started from the ones creating and serving a server through a profiler
+// label, which every goroutine inherits from the one starting it. Unlike the parent recorded in the stacks,
+// the label is still there once the parent exited, so the servers of parallel tests are told apart.
+type goroutineTree struct {
+	labels pprof.LabelSet
+	label  string
+}
+
+var lastTree int64
+
+func newGoroutineTree() *goroutineTree {
+	id := strconv.FormatInt(atomic.AddInt64(&lastTree, 1), 10)
+	return &goroutineTree{
+		labels: pprof.Labels("srvtest", id),
+		label:  `"srvtest":"` + id + `"`,
+	}
+}
+
+// do runs f with the label of the tree, the goroutines it starts belong to the tree
+func (g *goroutineTree) do(f func()) {
+	pprof.Do(context.Background(), g.labels, func(context.Context) {
+		f()
+	})
+}
+
+// wait waits for the goroutines of the tree to exit, and returns the stacks of the ones left
+func (g *goroutineTree) wait(timeout time.Duration) string {
+	deadline := time.Now().Add(timeout)
+	for {
+		leaked := g.stacks()
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			return strings.Join(leaked, "\n\n")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stacks returns the stacks of the running goroutines of the tree, the goroutines with the same stack
+// are listed once with their count
+func (g *goroutineTree) stacks() []string {
+	var buf bytes.Buffer
+	_ = pprof.Lookup("goroutine").WriteTo(&buf, 1)
+
+	var owned []string
+	// 2 @ 0x43c5d6 0x44b6a5
+	// # labels: {"srvtest":"1"}
+	// #	0x44b6a4	net/http.(*conn).serve+0x5a4	/usr/local/go/src/net/http/server.go:2039
+	for _, record := range strings.Split(buf.String(), "\n\n") {
+		for _, line := range strings.Split(record, "\n") {
+			if strings.HasPrefix(line, "# labels: ") && strings.Contains(line, g.label) {
+				owned = append(owned, record)
+				break
+			}
+		}
+	}
+	return owned
+}
+
+// LogRecorder is a log.Logger keeping every line in memory, Fatalf is recorded instead of exiting
+type LogRecorder struct {
+	mu       sync.Mutex
+	lines    []string
+	fatalMsg *string
+}
+
+func (l *LogRecorder) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *LogRecorder) Fatalf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, msg)
+	if l.fatalMsg == nil {
+		l.fatalMsg = &msg
+	}
+}
+
+// Lines returns a copy of the recorded lines
+func (l *LogRecorder) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+// Contains tells if any recorded line contains the text
+func (l *LogRecorder) Contains(text string) bool {
+	for _, line := range l.Lines() {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *LogRecorder) fatal() (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fatalMsg == nil {
+		return "", false
+	}
+	return *l.fatalMsg, true
+}
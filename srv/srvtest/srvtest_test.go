@@ -0,0 +1,101 @@
+package srvtest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabibotos/go-srv/srv"
+)
+
+func TestStart(t *testing.T) {
+	ts := Start(t, srv.HandlesRequestsWith(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})))
+
+	resp, err := ts.AppClient.Get(ts.AppURL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "hello" {
+		t.Fatalf("wrong app response: got %s want %s", body, "hello")
+	}
+
+	resp, err = ts.SystemClient.Get(ts.SystemURL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong system status: got %d want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if !ts.Logs.Contains("Serving at app://127.0.0.1") {
+		t.Fatalf("listener not logged: %q", ts.Logs.Lines())
+	}
+}
+
+func TestStartBesideRunningServer(t *testing.T) {
+	Start(t)
+
+	t.Run("nested", func(t *testing.T) {
+		// the server of the parent test is still running when this one is checked for leaks
+		Start(t)
+	})
+	if t.Failed() {
+		t.Fatal("goroutines of the running server were reported as leaked")
+	}
+}
+
+// recordingTB records the failures and the cleanups of a test, to check the harness fails it
+type recordingTB struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingTB) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func TestStartReportsLeakedGoroutines(t *testing.T) {
+	timeout := ShutdownTimeout
+	ShutdownTimeout = 200 * time.Millisecond
+	defer func() { ShutdownTimeout = timeout }()
+
+	release := make(chan struct{})
+	defer close(release)
+	rec := &recordingTB{TB: t}
+	ts := Start(rec, srv.HandlesRequestsWith(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		go leakedWorker(release)
+	})))
+
+	resp, err := ts.AppClient.Get(ts.AppURL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	ts.AppClient.CloseIdleConnections()
+	// the connection which started the goroutine is gone before the check
+	time.Sleep(50 * time.Millisecond)
+
+	for _, cleanup := range rec.cleanups {
+		cleanup()
+	}
+	if len(rec.errors) != 1 || !strings.Contains(rec.errors[0], "leakedWorker") {
+		t.Fatalf("the leaked goroutine wasn't reported: got %q", rec.errors)
+	}
+}
+
+func leakedWorker(release chan struct{}) {
+	<-release
+}